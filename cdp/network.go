@@ -1,6 +1,11 @@
 package cdp
 
-import "context"
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
 
 type Cookie struct {
 	Name         string  `json:"name,omitempty"`  //必填
@@ -21,6 +26,65 @@ type Cookie struct {
 	Size         int64   `json:"size,omitempty"`
 }
 
+// http.Cookie 转换为cdp.Cookie,用于把requests.Client 的cookie 设置到浏览器
+func NewCookies(cookies ...*http.Cookie) []Cookie {
+	result := make([]Cookie, 0, len(cookies))
+	for _, cookie := range cookies {
+		cook := Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		}
+		switch cookie.SameSite {
+		case http.SameSiteStrictMode:
+			cook.SameSite = "Strict"
+		case http.SameSiteLaxMode:
+			cook.SameSite = "Lax"
+		case http.SameSiteNoneMode:
+			cook.SameSite = "None"
+		}
+		if cookie.MaxAge > 0 {
+			cook.Expires = float64(time.Now().Add(time.Duration(cookie.MaxAge) * time.Second).Unix())
+		} else if !cookie.Expires.IsZero() {
+			cook.Expires = float64(cookie.Expires.Unix())
+		} else {
+			cook.Session = true
+		}
+		result = append(result, cook)
+	}
+	return result
+}
+
+// cdp.Cookie 转换为http.Cookie,用于把浏览器的cookie 导入requests.Client
+func HttpCookies(cookies ...Cookie) []*http.Cookie {
+	result := make([]*http.Cookie, 0, len(cookies))
+	for _, cookie := range cookies {
+		cook := &http.Cookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+		}
+		switch strings.ToLower(cookie.SameSite) {
+		case "strict":
+			cook.SameSite = http.SameSiteStrictMode
+		case "lax":
+			cook.SameSite = http.SameSiteLaxMode
+		case "none":
+			cook.SameSite = http.SameSiteNoneMode
+		}
+		if !cookie.Session && cookie.Expires > 0 {
+			cook.Expires = time.Unix(int64(cookie.Expires), 0)
+		}
+		result = append(result, cook)
+	}
+	return result
+}
 func (obj *WebSock) NetworkSetCookies(preCtx context.Context, cookies []Cookie) (RecvData, error) {
 	return obj.send(preCtx, commend{
 		Method: "Network.setCookies",
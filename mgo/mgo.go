@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
 	"time"
 
@@ -857,3 +858,35 @@ func ClearOplog(oplogData map[string]any) Oplog {
 	result.ObjectID = hid
 	return result
 }
+
+// cookie 存储,可用于requests.NewJar
+type CookieStore struct {
+	table *Table
+	key   string
+}
+
+// 创建cookie 存储,cookie 以json 保存在_id 为key 的文档中
+func (obj *Table) NewCookieStore(key string) *CookieStore {
+	return &CookieStore{table: obj, key: key}
+}
+func (obj *CookieStore) Load() ([]*http.Cookie, error) {
+	cookies := []*http.Cookie{}
+	rs, err := obj.table.Find(context.TODO(), map[string]any{"_id": obj.key})
+	if err != nil || rs == nil {
+		return cookies, err
+	}
+	val := rs.Json().Get("cookies").String()
+	if val == "" {
+		return cookies, nil
+	}
+	return cookies, json.Unmarshal(tools.StringToBytes(val), &cookies)
+}
+func (obj *CookieStore) Save(cookies []*http.Cookie) error {
+	con, err := json.Marshal(cookies)
+	if err != nil {
+		return err
+	}
+	upsert := true
+	_, err = obj.table.object.UpdateOne(context.TODO(), map[string]any{"_id": obj.key}, map[string]any{"$set": map[string]any{"cookies": tools.BytesToString(con)}}, &options.UpdateOptions{Upsert: &upsert})
+	return err
+}
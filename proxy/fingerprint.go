@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+)
+
+// 使用浏览器指纹重新请求服务器的client,每个上游代理与会话一个client,连接池不会跨代理复用,空闲超时后关闭
+type fingerprintClients struct {
+	ctx       context.Context
+	option    requests.ClientOption
+	lock      sync.Mutex
+	clients   map[string]*fingerprintClient //key:上游代理;会话
+	clearTime time.Time                     //上次清理空闲client 的时间
+}
+type fingerprintClient struct {
+	client   *requests.Client
+	active   int       //正在使用的请求数,lock
+	lastUsed time.Time //最后使用的时间,lock
+}
+
+// 空闲的client 超过这个时间后关闭
+const fingerprintIdleTimeout = time.Second * 30
+
+// 中间人设置了浏览器指纹时,创建重新请求服务器的client 池,没有设置时返回nil
+func newFingerprintClients(ctx context.Context, option ClientOption) (*fingerprintClients, error) {
+	mitmOption := option.Mitm
+	if mitmOption.Profile == nil && !mitmOption.Ja3Spec.IsSet() && !mitmOption.H2Ja3Spec.IsSet() {
+		return nil, nil
+	}
+	reqOption := requests.ClientOption{
+		Profile:        mitmOption.Profile,
+		Ja3Spec:        mitmOption.Ja3Spec,
+		H2Ja3Spec:      mitmOption.H2Ja3Spec,
+		DisCookie:      true, //cookie 由客户端管理
+		DisCompression: true, //响应体原样返回给客户端
+		LocalAddr:      option.LocalAddr,
+		LocalAddrs:     option.LocalAddrs,
+		Tls:            &requests.TlsOption{Verify: !mitmOption.InsecureSkipVerify},
+	}
+	if !reqOption.Ja3Spec.IsSet() && reqOption.Profile == nil { //只设置了http2指纹时,ja3 使用默认的浏览器指纹
+		profile, err := requests.GetProfile("chrome")
+		if err != nil {
+			return nil, err
+		}
+		reqOption.Ja3Spec = profile.Ja3Spec
+	}
+	client, err := requests.NewClient(ctx, reqOption) //提前检查参数
+	if err != nil {
+		return nil, err
+	}
+	return &fingerprintClients{
+		ctx:     ctx,
+		option:  reqOption,
+		clients: map[string]*fingerprintClient{"": {client: client}},
+	}, nil
+}
+
+// 获取上游代理与会话对应的client,同时关闭空闲超时的client
+func (obj *fingerprintClients) get(ip_addr string, key string) (*fingerprintClient, error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	now := time.Now()
+	if now.Sub(obj.clearTime) > fingerprintIdleTimeout {
+		for k, client := range obj.clients {
+			if client.active == 0 && now.Sub(client.lastUsed) > fingerprintIdleTimeout {
+				client.client.Close()
+				delete(obj.clients, k)
+			}
+		}
+		obj.clearTime = now
+	}
+	clientKey := ip_addr
+	if key != "" {
+		clientKey += ";" + key
+	}
+	client, ok := obj.clients[clientKey]
+	if !ok {
+		option := obj.option
+		option.Proxy = ip_addr
+		reqCli, err := requests.NewClient(obj.ctx, option)
+		if err != nil {
+			return nil, err
+		}
+		client = &fingerprintClient{client: reqCli}
+		obj.clients[clientKey] = client
+	}
+	client.active++
+	client.lastUsed = now
+	return client, nil
+}
+func (obj *fingerprintClients) release(client *fingerprintClient) {
+	obj.lock.Lock()
+	client.active--
+	client.lastUsed = time.Now()
+	obj.lock.Unlock()
+}
+func (obj *fingerprintClients) Close() {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	for k, client := range obj.clients {
+		client.client.Close()
+		delete(obj.clients, k)
+	}
+}
+
+// 响应体关闭后释放client
+type fingerprintBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (obj *fingerprintBody) Close() error {
+	err := obj.ReadCloser.Close()
+	obj.once.Do(obj.release)
+	return err
+}
+
+// 使用浏览器指纹重新请求服务器,客户端发送的请求头原样转发,ja3,http2指纹与客户端无关
+type fingerprintTransport struct {
+	client    *Client
+	transport *http.Transport //协议升级的请求使用
+	ip_addr   string
+	key       string
+}
+
+func (obj *fingerprintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Upgrade") != "" { //websocket 等协议升级直接转发
+		return obj.transport.RoundTrip(req)
+	}
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	headers := req.Header.Clone()
+	for _, name := range []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Te", "Trailer", "Content-Length"} {
+		headers.Del(name)
+	}
+	mitm := obj.client.mitm
+	http2 := mitm.option.Http2
+	if _, ok := mitm.h1Host.Load(req.URL.Host); ok {
+		http2 = false
+	}
+	startTime := time.Now()
+	resp, err := obj.request(req, headers, body, http2)
+	if err != nil && http2 && errors.Is(err, requests.ErrH2NotSupport) { //服务器不支持http2,使用http1.1 重新请求
+		mitm.h1Host.Store(req.URL.Host, true)
+		resp, err = obj.request(req, headers, body, false)
+	}
+	if obj.ip_addr != "" {
+		obj.client.reportProxy(obj.key, obj.ip_addr, startTime, err)
+	}
+	return resp, err
+}
+func (obj *fingerprintTransport) request(req *http.Request, headers http.Header, body []byte, http2 bool) (*http.Response, error) {
+	clients := obj.client.mitm.clients
+	client, err := clients.get(obj.ip_addr, obj.key)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.client.Request(req.Context(), req.Method, req.URL.String(), requests.RequestOption{
+		Host:        req.Host,
+		Headers:     headers,
+		Bytes:       body,
+		Http2:       http2,
+		RedirectNum: -1, //重定向由客户端处理
+		DisCache:    true,
+		DisRead:     true,
+		DisUnZip:    true,
+		DisDecode:   true,
+	})
+	if err != nil {
+		if response != nil {
+			response.Close()
+		}
+		clients.release(client)
+		return nil, err
+	}
+	stream, err := response.Stream() //关闭时释放请求
+	if err != nil {
+		clients.release(client)
+		return nil, err
+	}
+	resp := *response.Response() //不修改Response 内部的响应,关闭stream 时会关闭内部的响应体
+	resp.Body = &fingerprintBody{ReadCloser: stream, release: func() { clients.release(client) }}
+	resp.Request = req
+	return &resp, nil
+}
@@ -0,0 +1,452 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/requests"
+	"golang.org/x/net/http2"
+)
+
+// 中间人参数,解密经过代理的https 流量,客户端需要信任ca 证书
+type MitmOption struct {
+	CaCert             []byte                             //pem 格式的ca 证书,为空时自动生成,可以通过Client.CaCert 获取后安装到客户端
+	CaKey              []byte                             //pem 格式的ca 私钥
+	Hosts              []string                           //只解密这些host,支持*.example.com,default:全部
+	RequestHook        func(*http.Request) *http.Response //修改请求,返回不为nil 时直接作为响应返回,不请求服务器
+	ResponseHook       func(*http.Response)               //修改响应,resp.Request 为对应的请求,响应体为服务器返回的原始内容,可能是压缩的
+	Har                *requests.HarRecorder              //记录解密后的请求与响应
+	Profile            *requests.Profile                  //浏览器指纹,设置后使用该指纹的ja3,http2指纹重新请求服务器,客户端原始的tls 指纹不会发送到服务器
+	Ja3Spec            requests.Ja3Spec                   //重新请求服务器的ja3指纹,优先于Profile
+	H2Ja3Spec          requests.H2Ja3Spec                 //重新请求服务器的http2指纹,优先于Profile
+	Http2              bool                               //使用http2 重新请求服务器,服务器不支持http2 时使用http1.1,设置Profile 或H2Ja3Spec 时默认开启
+	InsecureSkipVerify bool                               //不验证服务器证书,default:验证
+}
+
+type mitm struct {
+	option  MitmOption
+	caCert  *x509.Certificate
+	caKey   crypto.Signer
+	caPem   []byte
+	certs   map[string]*tls.Certificate //key:host
+	lock    sync.Mutex
+	clients *fingerprintClients //使用浏览器指纹重新请求服务器的client
+	h1Host  sync.Map            //不支持http2 的host
+}
+
+func newMitm(option *MitmOption) (*mitm, error) {
+	obj := &mitm{
+		option: *option,
+		certs:  make(map[string]*tls.Certificate),
+	}
+	if option.Profile != nil || option.H2Ja3Spec.IsSet() { //设置了http2指纹时默认使用http2
+		obj.option.Http2 = true
+	}
+	caCert, caKey := option.CaCert, option.CaKey
+	if len(caCert) == 0 {
+		var err error
+		if caCert, caKey, err = CreateCa("gospider"); err != nil {
+			return nil, err
+		}
+	}
+	ca, err := tls.X509KeyPair(caCert, caKey)
+	if err != nil {
+		return nil, err
+	}
+	if obj.caCert, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+		return nil, err
+	}
+	if !obj.caCert.IsCA {
+		return nil, errors.New("CaCert 不是ca 证书")
+	}
+	var ok bool
+	if obj.caKey, ok = ca.PrivateKey.(crypto.Signer); !ok {
+		return nil, errors.New("不支持的CaKey")
+	}
+	obj.caPem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	return obj, nil
+}
+
+// 生成ca 证书与私钥,pem 格式,可以保存后作为MitmOption 的CaCert,CaKey 使用
+func CreateCa(commonName string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{commonName}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+// 是否解密host 的流量
+func (obj *mitm) match(host string) bool {
+	if len(obj.option.Hosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range obj.option.Hosts {
+		pattern = strings.ToLower(pattern)
+		if pattern == host || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// 使用ca 签发host 的证书,签发后缓存
+func (obj *mitm) getCert(host string) (*tls.Certificate, error) {
+	host = strings.ToLower(host)
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if cert, ok := obj.certs[host]; ok && time.Now().Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, obj.caCert, &key.PublicKey, obj.caKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, obj.caCert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	obj.certs[host] = cert
+	return cert, nil
+}
+
+// 中间人使用的ca 证书,pem 格式,没有开启中间人时返回nil
+func (obj *Client) CaCert() []byte {
+	if obj.mitm == nil {
+		return nil
+	}
+	return obj.mitm.caPem
+}
+
+// 读取时使用bufio.Reader 中缓存的数据
+type readerConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (obj *readerConn) Read(b []byte) (int, error) {
+	return obj.reader.Read(b)
+}
+
+// 解密隧道中的流量,tls 流量先与客户端握手,addr 为隧道的目标地址
+func (obj *Client) mitmTunnelHandle(ctx context.Context, client net.Conn, clientReader *bufio.Reader, addr string, ip_addr string, key string) error {
+	firstCons, err := clientReader.Peek(1)
+	if err != nil {
+		return err
+	}
+	if firstCons[0] != 22 { //不是tls 握手时按http 处理
+		return obj.mitmHttpHandle(ctx, client, clientReader, nil, "http", addr, ip_addr, key)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	tlsConn := tls.Server(&readerConn{Conn: client, reader: clientReader}, &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if chi.ServerName != "" {
+				return obj.mitm.getCert(chi.ServerName)
+			}
+			return obj.mitm.getCert(host)
+		},
+	})
+	defer tlsConn.Close()
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		return obj.mitmHttp2Handle(ctx, tlsConn, addr, ip_addr, key)
+	}
+	return obj.mitmHttpHandle(ctx, tlsConn, bufio.NewReader(tlsConn), nil, "https", addr, ip_addr, key)
+}
+
+// 客户端使用http2 时,每个stream 单独请求服务器
+func (obj *Client) mitmHttp2Handle(ctx context.Context, client net.Conn, addr string, ip_addr string, key string) error {
+	roundTripper, transport, err := obj.mitmRoundTripper(ip_addr, key)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	(&http2.Server{}).ServeConn(client, &http2.ServeConnOpts{
+		Context: ctx,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp, err := obj.mitmRoundTrip(r.Context(), roundTripper, r, "https", addr)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade", "Proxy-Connection"} {
+				resp.Header.Del(name)
+			}
+			for name, vals := range resp.Header {
+				w.Header()[name] = vals
+			}
+			w.WriteHeader(resp.StatusCode)
+			flusher, _ := w.(http.Flusher)
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := resp.Body.Read(buf)
+				if n > 0 {
+					if _, werr := w.Write(buf[:n]); werr != nil {
+						return
+					}
+					if flusher != nil { //流式响应及时发送
+						flusher.Flush()
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}),
+	})
+	return nil
+}
+
+// 解密后的http 请求,clientReq 不为nil 时作为第一个请求
+func (obj *Client) mitmHttpHandle(ctx context.Context, client net.Conn, clientReader *bufio.Reader, clientReq *http.Request, scheme string, addr string, ip_addr string, key string) error {
+	roundTripper, transport, err := obj.mitmRoundTripper(ip_addr, key)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	for {
+		if clientReq == nil {
+			if clientReq, err = http.ReadRequest(clientReader); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+		}
+		resp, err := obj.mitmRoundTrip(ctx, roundTripper, clientReq, scheme, addr)
+		if err != nil {
+			client.Write([]byte(fmt.Sprintf("%s 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", clientReq.Proto)))
+			return err
+		}
+		if resp.StatusCode == http.StatusSwitchingProtocols { //websocket 等协议升级后直接转发
+			return obj.mitmUpgrade(client, clientReader, resp)
+		}
+		err = writeMitmResponse(client, resp)
+		resp.Body.Close()
+		if err != nil || clientReq.Close || resp.Close {
+			return err
+		}
+		clientReq = nil
+	}
+}
+
+// 请求服务器,调用请求与响应的回调
+func (obj *Client) mitmRoundTrip(ctx context.Context, roundTripper http.RoundTripper, req *http.Request, scheme string, addr string) (*http.Response, error) {
+	req.RequestURI = ""
+	req.URL.Scheme = scheme
+	if req.URL.Host == "" {
+		if req.Host != "" {
+			req.URL.Host = req.Host
+		} else {
+			req.URL.Host = addr
+		}
+	}
+	obj.clearClientReq(req, nil)
+	req = req.WithContext(ctx)
+	if obj.mitm.option.Har != nil && req.Body != nil && req.Body != http.NoBody { //记录请求体
+		if err := setMitmBody(req); err != nil {
+			return nil, err
+		}
+	}
+	var resp *http.Response
+	if obj.mitm.option.RequestHook != nil {
+		body := req.Body
+		if resp = obj.mitm.option.RequestHook(req); resp == nil && req.Body != body { //修改了请求体,重新计算长度
+			if err := setMitmBody(req); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if resp == nil {
+		var err error
+		if resp, err = roundTripper.RoundTrip(req); err != nil {
+			return nil, err
+		}
+	}
+	if resp.Request == nil {
+		resp.Request = req
+	}
+	if resp.Body == nil {
+		resp.Body = http.NoBody
+	}
+	if obj.mitm.option.ResponseHook != nil {
+		body := resp.Body
+		if obj.mitm.option.ResponseHook(resp); resp.Body != body { //修改了响应体,使用chunked 发送
+			resp.ContentLength = -1
+			resp.Header.Del("Content-Length")
+		}
+	}
+	return resp, nil
+}
+
+// 读取请求体,设置长度与GetBody
+func setMitmBody(req *http.Request) error {
+	var con []byte
+	if req.Body != nil {
+		var err error
+		if con, err = io.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+	}
+	req.ContentLength = int64(len(con))
+	req.Header.Del("Content-Length")
+	req.TransferEncoding = nil
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(con)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return nil
+}
+
+// 以http1.1 返回给客户端,长度未知时使用chunked
+func writeMitmResponse(client net.Conn, resp *http.Response) error {
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+	bodyAllowed := resp.StatusCode >= 200 && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified && resp.Request.Method != http.MethodHead
+	if resp.ContentLength == -1 && bodyAllowed {
+		resp.TransferEncoding = []string{"chunked"}
+	} else {
+		resp.TransferEncoding = nil
+	}
+	return resp.Write(client)
+}
+func (obj *Client) mitmUpgrade(client net.Conn, clientReader *bufio.Reader, resp *http.Response) error {
+	server, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return errors.New("协议升级的响应体不可写")
+	}
+	defer server.Close()
+	if _, err := fmt.Fprintf(client, "HTTP/1.1 %s\r\n", resp.Status); err != nil {
+		return err
+	}
+	if err := resp.Header.Write(client); err != nil {
+		return err
+	}
+	if _, err := client.Write([]byte("\r\n")); err != nil {
+		return err
+	}
+	go func() { //服务端到客户端
+		defer server.Close()
+		defer client.Close()
+		io.Copy(client, server)
+	}()
+	_, err := io.Copy(server, clientReader) //客户端发送服务端
+	return err
+}
+
+// 请求服务器的RoundTripper,设置Har 时记录请求与响应
+func (obj *Client) mitmRoundTripper(ip_addr string, key string) (http.RoundTripper, *http.Transport, error) {
+	transport, err := obj.mitmTransport(ip_addr, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	var roundTripper http.RoundTripper = transport
+	if obj.mitm.clients != nil { //使用浏览器指纹
+		roundTripper = &fingerprintTransport{client: obj, transport: transport, ip_addr: ip_addr, key: key}
+	}
+	if obj.mitm.option.Har != nil {
+		roundTripper = requests.NewHarTransport(roundTripper, obj.mitm.option.Har)
+	}
+	return roundTripper, transport, nil
+}
+
+// 请求服务器的transport,ip_addr 不为空时使用上游代理
+func (obj *Client) mitmTransport(ip_addr string, key string) (*http.Transport, error) {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			startTime := time.Now()
+			conn, err := obj.dialer.DialContext(ctx, network, addr)
+			if ip_addr != "" {
+				obj.reportProxy(key, ip_addr, startTime, err)
+			}
+			return conn, err
+		},
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: obj.mitm.option.InsecureSkipVerify},
+		ForceAttemptHTTP2:   true,
+		DisableCompression:  true,
+		TLSHandshakeTimeout: time.Second * 15,
+		IdleConnTimeout:     time.Second * 30,
+	}
+	if ip_addr != "" {
+		ipUrl, err := obj.verifyProxy(ip_addr)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(ipUrl)
+	}
+	return transport, nil
+}
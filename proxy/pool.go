@@ -0,0 +1,418 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/redis"
+	"gitee.com/baixudong/gospider/requests"
+)
+
+// 上游代理池参数,Proxys,File,Redis 可以同时使用
+type PoolOption struct {
+	Proxys        []string      //代理列表,如:http://usr:pwd@ip:port,socks5://ip:port,没有协议时为http
+	File          string        //代理文件,每行一个代理,#开头的行会被忽略
+	Redis         *redis.Client //从redis 的hash 中加载代理,使用redis.Client.GetProxys
+	RedisKey      string        //redis 中代理hash 的key
+	LoadInterval  time.Duration //重新加载代理的间隔,default:不重新加载
+	CheckUrl      string        //健康检查的地址,default:不检查
+	CheckInterval time.Duration //健康检查的间隔,default:60s
+	CheckTimeout  time.Duration //健康检查的超时时间,default:8s
+	CheckThread   int           //健康检查的并发数,default:20
+	MaxFail       int           //连续失败次数达到后开始冷却,default:3
+	CoolDown      time.Duration //冷却时间,冷却中的代理不会被选择,default:5min
+	MaxCoolNum    int           //连续冷却次数达到后移除代理,重新加载时如果代理仍然存在会重新加入,default:3
+	Strategy      string        //选择策略:round(轮询),weight(按分数加权随机),latency(最低延迟),sticky(按客户端固定),default:round
+}
+
+// 代理的统计信息
+type ProxyStat struct {
+	Proxy     string        //代理
+	Latency   time.Duration //平均延迟
+	Success   int64         //成功次数
+	Fail      int64         //失败次数
+	Score     float64       //分数,越大越好
+	CoolUntil time.Time     //冷却结束时间
+}
+
+type poolProxy struct {
+	proxy     string
+	latency   time.Duration
+	success   int64
+	fail      int64
+	failNum   int //连续失败次数
+	coolNum   int //连续冷却次数
+	coolUntil time.Time
+}
+
+// 成功率除以延迟,没有延迟数据时按1s 计算
+func (obj *poolProxy) score() float64 {
+	rate := float64(obj.success+1) / float64(obj.success+obj.fail+2)
+	latency := obj.latency.Seconds()
+	if latency == 0 {
+		latency = 1
+	}
+	return rate * rate / (0.1 + latency)
+}
+
+type ProxyPool struct {
+	option  PoolOption
+	proxys  []*poolProxy
+	sticky  map[string]*poolProxy //key:客户端
+	index   int
+	checker *requests.Client
+	lock    sync.Mutex
+	ctx     context.Context
+	cnl     context.CancelFunc
+}
+
+// 创建上游代理池,设置CheckUrl 或LoadInterval 时在后台检查与加载代理
+func NewProxyPool(preCtx context.Context, option PoolOption) (*ProxyPool, error) {
+	if preCtx == nil {
+		preCtx = context.TODO()
+	}
+	if option.CheckInterval == 0 {
+		option.CheckInterval = time.Second * 60
+	}
+	if option.CheckTimeout == 0 {
+		option.CheckTimeout = time.Second * 8
+	}
+	if option.CheckThread == 0 {
+		option.CheckThread = 20
+	}
+	if option.MaxFail == 0 {
+		option.MaxFail = 3
+	}
+	if option.CoolDown == 0 {
+		option.CoolDown = time.Minute * 5
+	}
+	if option.MaxCoolNum == 0 {
+		option.MaxCoolNum = 3
+	}
+	switch option.Strategy {
+	case "":
+		option.Strategy = "round"
+	case "round", "weight", "latency", "sticky":
+	default:
+		return nil, errors.New("不支持的代理选择策略:" + option.Strategy)
+	}
+	ctx, cnl := context.WithCancel(preCtx)
+	obj := &ProxyPool{
+		option: option,
+		sticky: make(map[string]*poolProxy),
+		ctx:    ctx,
+		cnl:    cnl,
+	}
+	if err := obj.Load(); err != nil {
+		cnl()
+		return nil, err
+	}
+	if option.CheckUrl != "" {
+		var err error
+		if obj.checker, err = requests.NewClient(ctx, requests.ClientOption{DisAlive: true, DisCookie: true}); err != nil {
+			cnl()
+			return nil, err
+		}
+		go obj.checkMain()
+	}
+	if option.LoadInterval > 0 {
+		go obj.loadMain()
+	}
+	return obj, nil
+}
+
+// 代理没有协议时添加http
+func parsePoolProxy(proxy string) (string, bool) {
+	proxy = strings.TrimSpace(proxy)
+	if proxy == "" {
+		return "", false
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	href, err := url.Parse(proxy)
+	if err != nil || href.Host == "" || (href.Scheme != "http" && href.Scheme != "socks5") {
+		return "", false
+	}
+	return href.String(), true
+}
+
+// 从所有来源加载代理,保留已有代理的统计信息
+func (obj *ProxyPool) Load() error {
+	proxys := append([]string{}, obj.option.Proxys...)
+	if obj.option.File != "" {
+		file, err := os.Open(obj.option.File)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				proxys = append(proxys, line)
+			}
+		}
+		file.Close()
+		if err = scanner.Err(); err != nil {
+			return err
+		}
+	}
+	if obj.option.Redis != nil {
+		redisProxys, err := obj.option.Redis.GetProxys(obj.option.RedisKey)
+		if err != nil {
+			return err
+		}
+		proxys = append(proxys, redisProxys...)
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	olds := make(map[string]*poolProxy)
+	for _, proxy := range obj.proxys {
+		olds[proxy.proxy] = proxy
+	}
+	news := []*poolProxy{}
+	for _, proxy := range proxys {
+		proxy, ok := parsePoolProxy(proxy)
+		if !ok {
+			continue
+		}
+		if old, ok := olds[proxy]; ok {
+			news = append(news, old)
+			delete(olds, proxy)
+		} else if !obj.has(news, proxy) {
+			news = append(news, &poolProxy{proxy: proxy})
+		}
+	}
+	if len(news) == 0 {
+		return errors.New("没有加载到代理")
+	}
+	obj.proxys = news
+	obj.clearSticky()
+	return nil
+}
+func (obj *ProxyPool) has(proxys []*poolProxy, proxy string) bool {
+	for _, poolProxy := range proxys {
+		if poolProxy.proxy == proxy {
+			return true
+		}
+	}
+	return false
+}
+
+// 删除已经不在池中的固定代理
+func (obj *ProxyPool) clearSticky() {
+	for key, proxy := range obj.sticky {
+		if !obj.has(obj.proxys, proxy.proxy) {
+			delete(obj.sticky, key)
+		}
+	}
+}
+func (obj *ProxyPool) loadMain() {
+	ticker := time.NewTicker(obj.option.LoadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-obj.ctx.Done():
+			return
+		case <-ticker.C:
+			obj.Load()
+		}
+	}
+}
+func (obj *ProxyPool) checkMain() {
+	for {
+		obj.Check()
+		select {
+		case <-obj.ctx.Done():
+			return
+		case <-time.After(obj.option.CheckInterval):
+		}
+	}
+}
+
+// 检查所有不在冷却中的代理
+func (obj *ProxyPool) Check() {
+	if obj.checker == nil {
+		return
+	}
+	now := time.Now()
+	proxys := []string{}
+	obj.lock.Lock()
+	for _, proxy := range obj.proxys {
+		if proxy.coolUntil.Before(now) {
+			proxys = append(proxys, proxy.proxy)
+		}
+	}
+	obj.lock.Unlock()
+	var wait sync.WaitGroup
+	threads := make(chan struct{}, obj.option.CheckThread)
+	for _, proxy := range proxys {
+		threads <- struct{}{}
+		wait.Add(1)
+		go func(proxy string) {
+			defer func() {
+				<-threads
+				wait.Done()
+			}()
+			latency, err := obj.check(proxy)
+			obj.Report(proxy, latency, err)
+		}(proxy)
+	}
+	wait.Wait()
+}
+func (obj *ProxyPool) check(proxy string) (time.Duration, error) {
+	ctx, cnl := context.WithTimeout(obj.ctx, obj.option.CheckTimeout)
+	defer cnl()
+	startTime := time.Now()
+	resp, err := obj.checker.Request(ctx, "get", obj.option.CheckUrl, requests.RequestOption{Proxy: proxy, DisRead: true})
+	if err != nil {
+		return 0, err
+	}
+	resp.Close()
+	if resp.StatusCode() >= 400 {
+		return 0, errors.New("健康检查的状态码错误:" + resp.Status())
+	}
+	return time.Since(startTime), nil
+}
+
+// 报告代理的使用结果,err 为nil 时latency 为本次延迟,连续失败达到MaxFail 时开始冷却
+func (obj *ProxyPool) Report(proxy string, latency time.Duration, err error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	var target *poolProxy
+	for _, p := range obj.proxys {
+		if p.proxy == proxy {
+			target = p
+			break
+		}
+	}
+	if target == nil {
+		return
+	}
+	if err == nil {
+		target.success++
+		target.failNum = 0
+		target.coolNum = 0
+		if target.latency == 0 {
+			target.latency = latency
+		} else {
+			target.latency = (target.latency*7 + latency*3) / 10
+		}
+		return
+	}
+	target.fail++
+	target.failNum++
+	if target.failNum < obj.option.MaxFail {
+		return
+	}
+	target.failNum = 0
+	target.coolNum++
+	if target.coolNum < obj.option.MaxCoolNum {
+		target.coolUntil = time.Now().Add(obj.option.CoolDown)
+		return
+	}
+	for i, p := range obj.proxys { //移除代理
+		if p == target {
+			obj.proxys = append(obj.proxys[:i], obj.proxys[i+1:]...)
+			break
+		}
+	}
+	obj.clearSticky()
+}
+
+// 按策略获取一个代理,可以直接作为MergeProxy 的getProxy
+func (obj *ProxyPool) Get() (string, error) {
+	return obj.GetWithKey("")
+}
+
+// 按策略获取一个代理,sticky 策略时相同的key 返回相同的代理
+func (obj *ProxyPool) GetWithKey(key string) (string, error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	now := time.Now()
+	proxys := []*poolProxy{}
+	for _, proxy := range obj.proxys {
+		if proxy.coolUntil.Before(now) {
+			proxys = append(proxys, proxy)
+		}
+	}
+	if len(proxys) == 0 {
+		return "", errors.New("没有可用的代理")
+	}
+	var proxy *poolProxy
+	switch obj.option.Strategy {
+	case "weight":
+		proxy = weightProxy(proxys)
+	case "latency":
+		for _, p := range proxys {
+			if p.latency > 0 && (proxy == nil || p.latency < proxy.latency) {
+				proxy = p
+			}
+		}
+		if proxy == nil { //还没有延迟数据
+			proxy = obj.roundProxy(proxys)
+		}
+	case "sticky":
+		if proxy = obj.sticky[key]; proxy == nil || !proxy.coolUntil.Before(now) {
+			proxy = weightProxy(proxys)
+			obj.sticky[key] = proxy
+		}
+	default:
+		proxy = obj.roundProxy(proxys)
+	}
+	return proxy.proxy, nil
+}
+func (obj *ProxyPool) roundProxy(proxys []*poolProxy) *poolProxy {
+	proxy := proxys[obj.index%len(proxys)]
+	obj.index++
+	return proxy
+}
+func weightProxy(proxys []*poolProxy) *poolProxy {
+	var total float64
+	for _, proxy := range proxys {
+		total += proxy.score()
+	}
+	num := rand.Float64() * total
+	for _, proxy := range proxys {
+		if num -= proxy.score(); num < 0 {
+			return proxy
+		}
+	}
+	return proxys[len(proxys)-1]
+}
+
+// 所有代理的统计信息,按分数从高到低排序
+func (obj *ProxyPool) Stats() []ProxyStat {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	stats := make([]ProxyStat, len(obj.proxys))
+	for i, proxy := range obj.proxys {
+		stats[i] = ProxyStat{
+			Proxy:     proxy.proxy,
+			Latency:   proxy.latency,
+			Success:   proxy.success,
+			Fail:      proxy.fail,
+			Score:     proxy.score(),
+			CoolUntil: proxy.coolUntil,
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Score > stats[j].Score
+	})
+	return stats
+}
+
+// 停止后台的检查与加载
+func (obj *ProxyPool) Close() {
+	obj.cnl()
+	if obj.checker != nil {
+		obj.checker.Close()
+	}
+}
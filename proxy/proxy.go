@@ -2,14 +2,15 @@ package proxy
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -36,29 +37,91 @@ func MergeProxy(ctx context.Context, getProxy func() (string, error)) (*Client,
 	return proxyCli, proxyCli.Err
 }
 
+// 使用上游代理池合并代理,会根据连接结果更新代理的分数
+func MergeProxyPool(ctx context.Context, pool *ProxyPool) (*Client, error) {
+	if pool == nil {
+		return nil, errors.New("not found proxy pool for mergeProxy")
+	}
+	proxyCli, err := NewClient(ctx, ClientOption{
+		Host: "127.0.0.1",
+	})
+	if err != nil {
+		return proxyCli, err
+	}
+	proxyCli.Pool = pool
+	go proxyCli.Run()
+	return proxyCli, proxyCli.Err
+}
+
 type ClientOption struct {
-	Usr       string      //用户名
-	Pwd       string      //密码
-	IpWhite   []net.IP    //白名单 192.168.1.1,192.168.1.2
-	Dialer    *net.Dialer //连接的Dialer
-	LocalAddr string      //本地网卡出口
-	Port      int         //代理端口
-	Host      string      //代理host
+	Usr        string                    //用户名
+	Pwd        string                    //密码
+	IpWhite    []net.IP                  //白名单 192.168.1.1,192.168.1.2
+	Dialer     *net.Dialer               //连接的Dialer
+	LocalAddr  string                    //本地网卡出口
+	LocalAddrs *requests.LocalAddrOption //本地网卡出口ip 池,轮询,随机,按host 固定,设置后LocalAddr 无效
+	Port       int                       //代理端口
+	Host       string                    //代理host
+	Sticky     *StickyOption             //会话固定,相同会话的连接使用相同的上游代理
+	Mitm       *MitmOption               //中间人,解密https 流量,可以修改请求与响应,记录har
 }
 type netDial struct {
-	dialer *net.Dialer //连接的Dialer
+	dialer     *net.Dialer             //连接的Dialer
+	localAddrs *requests.LocalAddrPool //出口ip 池
 }
 
 func (obj *netDial) DialContext(ctx context.Context, network string, address string) (net.Conn, error) { //http conn
-	return obj.dialer.DialContext(ctx, network, address)
+	if obj.localAddrs == nil {
+		return obj.dialer.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	err = errors.New("没有与出口ip 版本相同的地址:" + host)
+	for _, addr := range addrs {
+		localIp := obj.localAddrs.Get(host, addr.IP)
+		if localIp == nil {
+			continue
+		}
+		dialer := *obj.dialer
+		dialer.LocalAddr = &net.TCPAddr{IP: localIp}
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port)); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// udp 使用的出口ip,与连接的出口ip 相同,没有设置时返回nil
+func (obj *netDial) localIp(host string, ip net.IP) (net.IP, error) {
+	if obj.localAddrs != nil {
+		if localIp := obj.localAddrs.Get(host, ip); localIp != nil {
+			return localIp, nil
+		}
+		return nil, errors.New("没有与出口ip 版本相同的地址:" + host)
+	}
+	if tcpAddr, ok := obj.dialer.LocalAddr.(*net.TCPAddr); ok {
+		return tcpAddr.IP, nil
+	}
+	return nil, nil
 }
 func (obj *netDial) Dial(network string, address string) (net.Conn, error) { //websock conn
-	return obj.dialer.Dial(network, address)
+	return obj.DialContext(context.TODO(), network, address)
 }
 
 type Client struct {
 	Proxy     string                 //代理ip 192.168.1.50:8888
 	GetProxy  func() (string, error) //代理ip 116.62.55.139:8888
+	Pool      *ProxyPool             //上游代理池,优先于GetProxy,Proxy
 	Debug     bool                   //是否打印debug
 	Err       error                  //错误
 	DisVerify bool                   //关闭验证
@@ -70,6 +133,8 @@ type Client struct {
 	pwd       string
 	verify    bool
 	ipWhite   *kinds.Set[string]
+	sticky    *stickyStore //会话固定
+	mitm      *mitm        //中间人
 	ctx       context.Context
 	cnl       context.CancelFunc
 }
@@ -102,7 +167,13 @@ func NewClient(pre_ctx context.Context, options ...ClientOption) (*Client, error
 			KeepAlive: time.Duration(10) * time.Second,
 		}
 	}
-	if option.LocalAddr != "" {
+	var localAddrs *requests.LocalAddrPool
+	if option.LocalAddrs != nil {
+		var err error
+		if localAddrs, err = requests.NewLocalAddrPool(*option.LocalAddrs); err != nil {
+			return nil, err
+		}
+	} else if option.LocalAddr != "" {
 		if !strings.Contains(option.LocalAddr, ":") {
 			option.LocalAddr += ":0"
 		}
@@ -112,13 +183,29 @@ func NewClient(pre_ctx context.Context, options ...ClientOption) (*Client, error
 		}
 		option.Dialer.LocalAddr = localaddr
 	}
+	if option.Sticky != nil {
+		var err error
+		if server.sticky, err = newStickyStore(option.Sticky); err != nil {
+			return nil, err
+		}
+	}
+	if option.Mitm != nil {
+		var err error
+		if server.mitm, err = newMitm(option.Mitm); err != nil {
+			return nil, err
+		}
+		if server.mitm.clients, err = newFingerprintClients(ctx, option); err != nil {
+			return nil, err
+		}
+	}
 	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", option.Host, option.Port)) //监听本地端口
 	if err != nil {
 		return nil, err
 	}
 	server.listener = l
 	server.dialer = &netDial{
-		dialer: option.Dialer,
+		dialer:     option.Dialer,
+		localAddrs: localAddrs,
 	}
 	return &server, nil
 }
@@ -155,6 +242,9 @@ func (obj *Client) Run() error {
 }
 func (obj *Client) Close() {
 	obj.listener.Close()
+	if obj.mitm != nil && obj.mitm.clients != nil {
+		obj.mitm.clients.Close()
+	}
 	obj.cnl()
 }
 func (obj *Client) Done() <-chan struct{} {
@@ -172,13 +262,22 @@ func (obj *Client) whiteVerify(client net.Conn) bool {
 	return true
 }
 
-// 返回:请求所有内容,第一行的内容被" "分割的数组,第一行的内容,error
-func (obj *Client) verifyPwd(client net.Conn, clientReq *http.Request) error {
-	if obj.verify && clientReq.Header.Get("Proxy-Authorization") != obj.basic && !obj.whiteVerify(client) { //验证密码是否正确
+// 验证用户名密码,返回用户名中的会话
+func (obj *Client) verifyPwd(client net.Conn, clientReq *http.Request) (string, error) {
+	var usr, pwd, session string
+	if auth := clientReq.Header.Get("Proxy-Authorization"); strings.HasPrefix(auth, "Basic ") {
+		if con, err := tools.Base64Decode(auth[6:]); err == nil {
+			usr, pwd, _ = strings.Cut(tools.BytesToString(con), ":")
+		}
+	}
+	if usr != obj.usr {
+		usr, session = parseSessionUser(usr)
+	}
+	if obj.verify && (usr != obj.usr || pwd != obj.pwd) && !obj.whiteVerify(client) { //验证密码是否正确
 		client.Write([]byte(fmt.Sprintf("%s 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic\r\n\r\n", clientReq.Proto)))
-		return errors.New("auth verify fail")
+		return session, errors.New("auth verify fail")
 	}
-	return nil
+	return session, nil
 }
 func (obj *Client) parseServerAddr(clientReq *http.Request) error {
 	if clientReq.URL.Hostname() == "" {
@@ -213,6 +312,75 @@ func (obj *Client) getHttpProxyConn(ctx context.Context, ipUrl *url.URL) (net.Co
 	return obj.dialer.DialContext(ctx, "tcp", net.JoinHostPort(ipUrl.Hostname(), ipUrl.Port()))
 }
 
+// 获取上游代理与会话的key,代理为空时直接连接
+func (obj *Client) getProxy(client net.Conn, session string, host string) (string, string, error) {
+	clientIp, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+	var key string
+	if obj.sticky != nil {
+		if key = obj.sticky.key(session, clientIp, host); key != "" {
+			if ip_addr := obj.sticky.get(key); ip_addr != "" {
+				return ip_addr, key, nil
+			}
+		}
+	}
+	var ip_addr string
+	var err error
+	if obj.Pool != nil {
+		if key != "" {
+			ip_addr, err = obj.Pool.GetWithKey(key)
+		} else {
+			ip_addr, err = obj.Pool.GetWithKey(clientIp)
+		}
+	} else if obj.GetProxy != nil {
+		ip_addr, err = obj.GetProxy()
+	} else {
+		ip_addr = obj.Proxy
+	}
+	if err == nil && key != "" && ip_addr != "" {
+		obj.sticky.set(key, ip_addr)
+	}
+	return ip_addr, key, err
+}
+
+// 读取上游http 代理的响应状态,不消耗数据,CONNECT 失败或需要代理认证时返回错误
+func proxyStatus(reader *bufio.Reader, connect bool) error {
+	con, err := reader.Peek(12)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(con))
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+		return errors.New("代理响应错误")
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return errors.New("代理响应错误")
+	}
+	if code == http.StatusProxyAuthRequired || (connect && code != http.StatusOK) {
+		return fmt.Errorf("代理响应状态码:%d", code)
+	}
+	return nil
+}
+
+// 收到http 上游代理的响应后报告结果,本地关闭连接时不报告
+func (obj *Client) reportHttpProxy(reader *bufio.Reader, connect bool, key string, ip_addr string, startTime time.Time) {
+	err := proxyStatus(reader, connect)
+	if errors.Is(err, net.ErrClosed) {
+		return
+	}
+	obj.reportProxy(key, ip_addr, startTime, err)
+}
+
+// 连接上游代理的结果报告给代理池,连接失败时结束会话
+func (obj *Client) reportProxy(key string, ip_addr string, startTime time.Time, err error) {
+	if obj.Pool != nil {
+		obj.Pool.Report(ip_addr, time.Since(startTime), err)
+	}
+	if err != nil && key != "" {
+		obj.sticky.del(key)
+	}
+}
+
 func (obj *Client) mainHandle(ctx context.Context, client net.Conn) error {
 	if client == nil {
 		return errors.New("client is nil")
@@ -253,22 +421,29 @@ func (obj *Client) httpHandle(ctx context.Context, client net.Conn, clientReader
 	if err != nil {
 		return err
 	}
-	if err = obj.verifyPwd(client, clientReq); err != nil {
+	session, err := obj.verifyPwd(client, clientReq)
+	if err != nil {
 		return err
 	}
-	var ip_addr string
-	if obj.GetProxy != nil {
-		ip_addr, err = obj.GetProxy()
-		if err != nil {
-			return err
-		}
-	} else if obj.Proxy != "" {
-		ip_addr = obj.Proxy
-	}
 	if err = obj.parseServerAddr(clientReq); err != nil {
 		return err
 	}
+	ip_addr, key, err := obj.getProxy(client, session, clientReq.URL.Hostname())
+	if err != nil {
+		return err
+	}
+	if obj.mitm != nil && obj.mitm.match(clientReq.URL.Hostname()) { //中间人解密
+		if clientReq.Method != http.MethodConnect {
+			return obj.mitmHttpHandle(ctx, client, clientReader, clientReq, "http", clientReq.URL.Host, ip_addr, key)
+		}
+		if _, err = client.Write([]byte(fmt.Sprintf("%s 200 Connection established\r\n\r\n", clientReq.Proto))); err != nil {
+			return err
+		}
+		return obj.mitmTunnelHandle(ctx, client, clientReader, clientReq.URL.Host, ip_addr, key)
+	}
 	var server net.Conn
+	var report func() //http 上游代理收到响应后报告结果
+	var serverReader io.Reader
 	if ip_addr == "" { //使用本地转发的逻辑
 		if server, err = obj.dialer.DialContext(ctx, "tcp", net.JoinHostPort(clientReq.URL.Hostname(), clientReq.URL.Port())); err != nil { //获取服务连接
 			return err
@@ -291,20 +466,30 @@ func (obj *Client) httpHandle(ctx context.Context, client net.Conn, clientReader
 		}
 		switch ipUrl.Scheme {
 		case "http":
+			startTime := time.Now()
 			if server, err = obj.getHttpProxyConn(ctx, ipUrl); err != nil { //获取服务连接
+				obj.reportProxy(key, ip_addr, startTime, err)
 				return err
 			}
 			defer server.Close()
 			obj.clearClientReq(clientReq, ipUrl)
 			if err = clientReq.Write(server); err != nil {
+				obj.reportProxy(key, ip_addr, startTime, err)
 				return err
 			}
+			reader := bufio.NewReader(server)
+			connect := clientReq.Method == http.MethodConnect
+			serverReader = reader
+			report = func() { obj.reportHttpProxy(reader, connect, key, ip_addr, startTime) }
 		case "socks5":
 			tempDial, err := netProxy.FromURL(ipUrl, obj.dialer)
 			if err != nil {
 				return err
 			}
-			if server, err = tempDial.Dial("tcp", net.JoinHostPort(clientReq.URL.Hostname(), clientReq.URL.Port())); err != nil { //获取服务连接
+			startTime := time.Now()
+			server, err = tempDial.Dial("tcp", net.JoinHostPort(clientReq.URL.Hostname(), clientReq.URL.Port()))
+			obj.reportProxy(key, ip_addr, startTime, err)
+			if err != nil { //获取服务连接
 				return err
 			}
 			defer server.Close()
@@ -322,10 +507,16 @@ func (obj *Client) httpHandle(ctx context.Context, client net.Conn, clientReader
 			return errors.New("不支持的代理协议")
 		}
 	}
+	if serverReader == nil {
+		serverReader = server
+	}
 	go func() { //服务端到客户端
 		defer server.Close()
 		defer client.Close()
-		io.Copy(client, server)
+		if report != nil {
+			report()
+		}
+		io.Copy(client, serverReader)
 	}()
 	if clientReq.Method != http.MethodConnect {
 		for {
@@ -357,117 +548,115 @@ func (obj *Client) tcpHandle(ctx context.Context, client net.Conn) error {
 	return err
 }
 
-func (obj *Client) getSocketAddr(clientReader *bufio.Reader) (string, error) {
-	buf := make([]byte, 4)
-	addr := ""
+// 读取socks5 的命令与地址
+func (obj *Client) getSocketAddr(clientReader *bufio.Reader) (byte, string, error) {
+	buf := make([]byte, 3)
 	_, err := io.ReadFull(clientReader, buf)
 	if err != nil {
-		return addr, fmt.Errorf("read header failed:%w", err)
+		return 0, "", fmt.Errorf("read header failed:%w", err)
 	}
-	ver, cmd, atyp := buf[0], buf[1], buf[3]
+	ver, cmd := buf[0], buf[1]
 	if ver != 5 {
-		return addr, fmt.Errorf("not supported ver:%v", ver)
-	}
-	if cmd != 1 {
-		return addr, fmt.Errorf("not supported cmd:%v", ver)
-	}
-	switch atyp {
-	case 1:
-		if _, err = io.ReadFull(clientReader, buf); err != nil {
-			return addr, fmt.Errorf("read atyp failed:%w", err)
-		}
-		addr = net.IPv4(buf[0], buf[1], buf[2], buf[3]).String()
-	case 3:
-		hostSize, err := clientReader.ReadByte()
-		if err != nil {
-			return addr, fmt.Errorf("read hostSize failed:%w", err)
-		}
-		host := make([]byte, hostSize)
-		if _, err = io.ReadFull(clientReader, host); err != nil {
-			return addr, fmt.Errorf("read host failed:%w", err)
-		}
-		addr = tools.BytesToString(host)
-	case 4:
-		host := make([]byte, 16)
-		if _, err = io.ReadFull(clientReader, host); err != nil {
-			return addr, fmt.Errorf("read atyp failed:%w", err)
-		}
-		addr = net.IP{host[0], host[1], host[2], host[3], host[4], host[5], host[6], host[7], host[8], host[9], host[10], host[11], host[12], host[13], host[14], host[15]}.String()
-	default:
-		return addr, errors.New("invalid atyp")
+		return cmd, "", fmt.Errorf("not supported ver:%v", ver)
 	}
-	if _, err = io.ReadFull(clientReader, buf[:2]); err != nil {
-		return addr, fmt.Errorf("read port failed:%w", err)
-	}
-	return fmt.Sprintf("%s:%d", addr, binary.BigEndian.Uint16(buf[:2])), nil
+	addr, err := readSocks5Addr(clientReader)
+	return cmd, addr, err
 }
-func (obj *Client) verifySocket(client net.Conn, clientReader *bufio.Reader) error {
+
+// 协商认证方式并验证用户名密码,返回用户名中的会话
+func (obj *Client) verifySocket(client net.Conn, clientReader *bufio.Reader) (string, error) {
 	ver, err := clientReader.ReadByte()
 	if err != nil {
-		return fmt.Errorf("read ver failed:%w", err)
+		return "", fmt.Errorf("read ver failed:%w", err)
 	}
 	if ver != 5 {
-		return fmt.Errorf("not supported ver:%v", ver)
+		return "", fmt.Errorf("not supported ver:%v", ver)
 	}
 	methodSize, err := clientReader.ReadByte()
 	if err != nil {
-		return fmt.Errorf("read methodSize failed:%w", err)
+		return "", fmt.Errorf("read methodSize failed:%w", err)
 	}
-	if _, err = io.ReadFull(clientReader, make([]byte, methodSize)); err != nil {
-		return fmt.Errorf("read method failed:%w", err)
+	methods := make([]byte, methodSize)
+	if _, err = io.ReadFull(clientReader, methods); err != nil {
+		return "", fmt.Errorf("read method failed:%w", err)
 	}
-	if obj.verify && !obj.whiteVerify(client) { //验证用户名密码
-		_, err = client.Write([]byte{5, 2})
-		if err != nil {
-			return err
-		}
-		okVar, err := clientReader.ReadByte()
-		if err != nil {
-			return err
-		}
-		Len, err := clientReader.ReadByte()
-		if err != nil {
-			return err
-		}
-		user := make([]byte, Len)
-		if _, err = io.ReadFull(clientReader, user); err != nil {
-			return err
-		}
-		if Len, err = clientReader.ReadByte(); err != nil {
-			return err
-		}
-		pass := make([]byte, Len)
-		if _, err = io.ReadFull(clientReader, pass); err != nil {
-			return err
-		}
-		if tools.BytesToString(user) != obj.usr || tools.BytesToString(pass) != obj.pwd {
-			client.Write([]byte{okVar, 0xff}) //用户名密码错误
-			return errors.New("用户名密码错误")
-		}
-		_, err = client.Write([]byte{okVar, 0}) //协商成功
-		return err
+	verify := obj.verify && !obj.whiteVerify(client)
+	if !verify && (obj.sticky == nil || !obj.sticky.hasMode("user") || bytes.IndexByte(methods, 2) == -1) {
+		_, err = client.Write([]byte{5, 0}) //协商成功
+		return "", err
 	}
-	_, err = client.Write([]byte{5, 0}) //协商成功
-	return err
+	//验证用户名密码,会话固定时读取用户名中的会话
+	if _, err = client.Write([]byte{5, 2}); err != nil {
+		return "", err
+	}
+	okVar, err := clientReader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	Len, err := clientReader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	user := make([]byte, Len)
+	if _, err = io.ReadFull(clientReader, user); err != nil {
+		return "", err
+	}
+	if Len, err = clientReader.ReadByte(); err != nil {
+		return "", err
+	}
+	pass := make([]byte, Len)
+	if _, err = io.ReadFull(clientReader, pass); err != nil {
+		return "", err
+	}
+	usr := tools.BytesToString(user)
+	var session string
+	if usr != obj.usr {
+		usr, session = parseSessionUser(usr)
+	}
+	if verify && (usr != obj.usr || tools.BytesToString(pass) != obj.pwd) {
+		client.Write([]byte{okVar, 0xff}) //用户名密码错误
+		return "", errors.New("用户名密码错误")
+	}
+	_, err = client.Write([]byte{okVar, 0}) //协商成功
+	return session, err
 }
 func (obj *Client) sockes5Handle(ctx context.Context, client net.Conn, clientReader *bufio.Reader) error {
 	defer client.Close()
 	var err error
-	if err = obj.verifySocket(client, clientReader); err != nil {
+	session, err := obj.verifySocket(client, clientReader)
+	if err != nil {
 		return err
 	}
-	serverAddr, err := obj.getSocketAddr(clientReader)
+	cmd, serverAddr, err := obj.getSocketAddr(clientReader)
 	if err != nil {
 		return err
 	}
-	var ip_addr string
+	if cmd != socks5Connect && cmd != socks5Bind && cmd != socks5Associate {
+		client.Write(socks5Reply(socks5CmdNotSupport, ""))
+		return fmt.Errorf("not supported cmd:%v", cmd)
+	}
 	var httpsByte byte
-	if obj.GetProxy != nil {
-		if ip_addr, err = obj.GetProxy(); err != nil {
+	var serverReader io.Reader
+	var report func() //http 上游代理收到响应后报告结果
+	serverHost, _, _ := net.SplitHostPort(serverAddr)
+	if cmd == socks5Associate { //udp 的地址是客户端发送数据包的地址,目标地址在数据包中
+		serverHost = ""
+	}
+	ip_addr, key, err := obj.getProxy(client, session, serverHost)
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case socks5Bind:
+		return obj.socks5BindHandle(ctx, client, clientReader, serverAddr, ip_addr, key)
+	case socks5Associate:
+		return obj.socks5UdpHandle(ctx, client, clientReader, ip_addr, key)
+	}
+	if obj.mitm != nil && obj.mitm.match(serverHost) { //中间人解密
+		if _, err = client.Write([]byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil { //响应客户端连接成功
 			return err
 		}
-	} else if obj.Proxy != "" {
-		ip_addr = obj.Proxy
+		return obj.mitmTunnelHandle(ctx, client, clientReader, serverAddr, ip_addr, key)
 	}
 	var server net.Conn
 	if ip_addr == "" {
@@ -484,7 +673,9 @@ func (obj *Client) sockes5Handle(ctx context.Context, client net.Conn, clientRea
 		}
 		switch ipUrl.Scheme {
 		case "http":
+			startTime := time.Now()
 			if server, err = obj.getHttpProxyConn(ctx, ipUrl); err != nil { //获取服务连接
+				obj.reportProxy(key, ip_addr, startTime, err)
 				return err
 			}
 			defer server.Close()
@@ -498,7 +689,9 @@ func (obj *Client) sockes5Handle(ctx context.Context, client net.Conn, clientRea
 			}
 			httpsByte = httpsBytes[0]
 			if httpsByte == 22 {
-				if err = requests.Http2httpsConn(ctx, ipUrl, serverAddr, serverAddr, server); err != nil {
+				err = requests.Http2httpsConn(ctx, ipUrl, serverAddr, serverAddr, server)
+				obj.reportProxy(key, ip_addr, startTime, err)
+				if err != nil {
 					return err
 				}
 			} else {
@@ -508,15 +701,22 @@ func (obj *Client) sockes5Handle(ctx context.Context, client net.Conn, clientRea
 				}
 				obj.clearClientReq(clientReq, ipUrl)
 				if err = clientReq.Write(server); err != nil {
+					obj.reportProxy(key, ip_addr, startTime, err)
 					return err
 				}
+				reader := bufio.NewReader(server)
+				serverReader = reader
+				report = func() { obj.reportHttpProxy(reader, false, key, ip_addr, startTime) }
 			}
 		case "socks5":
 			tempDial, err := netProxy.FromURL(ipUrl, obj.dialer)
 			if err != nil {
 				return err
 			}
-			if server, err = tempDial.Dial("tcp", serverAddr); err != nil { //获取服务连接
+			startTime := time.Now()
+			server, err = tempDial.Dial("tcp", serverAddr)
+			obj.reportProxy(key, ip_addr, startTime, err)
+			if err != nil { //获取服务连接
 				return err
 			}
 			defer server.Close()
@@ -527,10 +727,16 @@ func (obj *Client) sockes5Handle(ctx context.Context, client net.Conn, clientRea
 			return errors.New("代理协议不支持")
 		}
 	}
+	if serverReader == nil {
+		serverReader = server
+	}
 	go func() { //服务端到客户端
 		defer server.Close()
 		defer client.Close()
-		io.Copy(client, server)
+		if report != nil {
+			report()
+		}
+		io.Copy(client, serverReader)
 	}()
 	if httpsByte == 22 {
 		_, err = io.Copy(server, clientReader) //客户端发送服务端
@@ -0,0 +1,515 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// socks5 的命令
+const (
+	socks5Connect   = 1
+	socks5Bind      = 2
+	socks5Associate = 3
+)
+
+// socks5 响应的状态
+const (
+	socks5Succeeded     = 0
+	socks5Failure       = 1
+	socks5CmdNotSupport = 7
+)
+
+const (
+	socks5BindTimeout      = time.Minute * 2  //bind 等待服务器连接的时间
+	socks5HandshakeTimeout = time.Second * 15 //与上游代理握手的超时时间
+)
+
+// 读取socks5 的地址,atyp+addr+port
+func readSocks5Addr(reader *bufio.Reader) (string, error) {
+	atyp, err := reader.ReadByte()
+	if err != nil {
+		return "", fmt.Errorf("read atyp failed:%w", err)
+	}
+	var host string
+	switch atyp {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err = io.ReadFull(reader, ip); err != nil {
+			return "", fmt.Errorf("read atyp failed:%w", err)
+		}
+		host = net.IP(ip).String()
+	case 3:
+		hostSize, err := reader.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("read hostSize failed:%w", err)
+		}
+		hostBytes := make([]byte, hostSize)
+		if _, err = io.ReadFull(reader, hostBytes); err != nil {
+			return "", fmt.Errorf("read host failed:%w", err)
+		}
+		host = tools.BytesToString(hostBytes)
+	case 4:
+		ip := make([]byte, 16)
+		if _, err = io.ReadFull(reader, ip); err != nil {
+			return "", fmt.Errorf("read atyp failed:%w", err)
+		}
+		host = net.IP(ip).String()
+	default:
+		return "", errors.New("invalid atyp")
+	}
+	port := make([]byte, 2)
+	if _, err = io.ReadFull(reader, port); err != nil {
+		return "", fmt.Errorf("read port failed:%w", err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// 解析udp 数据包中的地址,返回地址与地址的长度
+func parseSocks5Addr(b []byte) (string, int, error) {
+	if len(b) < 1 {
+		return "", 0, errors.New("invalid addr")
+	}
+	var host string
+	var n int
+	switch b[0] {
+	case 1:
+		n = 1 + 4
+		if len(b) < n+2 {
+			return "", 0, errors.New("invalid addr")
+		}
+		host = net.IP(b[1:n]).String()
+	case 3:
+		if len(b) < 2 {
+			return "", 0, errors.New("invalid addr")
+		}
+		n = 2 + int(b[1])
+		if len(b) < n+2 {
+			return "", 0, errors.New("invalid addr")
+		}
+		host = string(b[2:n])
+	case 4:
+		n = 1 + 16
+		if len(b) < n+2 {
+			return "", 0, errors.New("invalid addr")
+		}
+		host = net.IP(b[1:n]).String()
+	default:
+		return "", 0, errors.New("invalid atyp")
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(b[n:n+2])))), n + 2, nil
+}
+
+// socks5 格式的地址,atyp+addr+port,addr 为空时使用0.0.0.0:0
+func socks5AddrBytes(addr string) []byte {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, portStr = "0.0.0.0", "0"
+	}
+	port, _ := strconv.Atoi(portStr)
+	var b []byte
+	if ip := net.ParseIP(host); ip == nil {
+		b = append([]byte{3, byte(len(host))}, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append([]byte{1}, ip4...)
+	} else {
+		b = append([]byte{4}, ip...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// socks5 命令的响应
+func socks5Reply(rep byte, addr string) []byte {
+	return append([]byte{5, rep, 0}, socks5AddrBytes(addr)...)
+}
+
+// 读取上游代理的响应,返回响应中的地址
+func readSocks5Reply(reader *bufio.Reader) (string, error) {
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", fmt.Errorf("read reply failed:%w", err)
+	}
+	if buf[0] != 5 {
+		return "", fmt.Errorf("not supported ver:%v", buf[0])
+	}
+	if buf[1] != socks5Succeeded {
+		return "", fmt.Errorf("socks5 代理响应错误:%v", buf[1])
+	}
+	return readSocks5Addr(reader)
+}
+
+// 响应中的地址是0.0.0.0 时使用代理的host
+func socks5BndAddr(ipUrl *url.URL, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return net.JoinHostPort(ipUrl.Hostname(), port)
+	}
+	return addr
+}
+
+// 连接上游socks5 代理,认证后发送命令,返回连接与代理响应的地址
+func (obj *Client) socks5Upstream(ctx context.Context, ipUrl *url.URL, cmd byte, addr string) (net.Conn, *bufio.Reader, string, error) {
+	conn, err := obj.dialer.DialContext(ctx, "tcp", net.JoinHostPort(ipUrl.Hostname(), ipUrl.Port()))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	bndAddr, reader, err := socks5Handshake(conn, ipUrl, cmd, addr)
+	if err != nil {
+		conn.Close()
+		return nil, nil, "", err
+	}
+	return conn, reader, socks5BndAddr(ipUrl, bndAddr), nil
+}
+func socks5Handshake(conn net.Conn, ipUrl *url.URL, cmd byte, addr string) (string, *bufio.Reader, error) {
+	conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	methods := []byte{5, 1, 0}
+	if ipUrl.User != nil {
+		methods = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return "", nil, err
+	}
+	reader := bufio.NewReader(conn)
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", nil, fmt.Errorf("read method failed:%w", err)
+	}
+	if buf[0] != 5 {
+		return "", nil, fmt.Errorf("not supported ver:%v", buf[0])
+	}
+	switch buf[1] {
+	case 0:
+	case 2:
+		if ipUrl.User == nil {
+			return "", nil, errors.New("上游代理需要用户名密码")
+		}
+		usr := ipUrl.User.Username()
+		pwd, _ := ipUrl.User.Password()
+		auth := append([]byte{1, byte(len(usr))}, usr...)
+		auth = append(append(auth, byte(len(pwd))), pwd...)
+		if _, err := conn.Write(auth); err != nil {
+			return "", nil, err
+		}
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return "", nil, fmt.Errorf("read auth failed:%w", err)
+		}
+		if buf[1] != 0 {
+			return "", nil, errors.New("上游代理用户名密码错误")
+		}
+	default:
+		return "", nil, errors.New("上游代理不支持的认证方式")
+	}
+	if _, err := conn.Write(append([]byte{5, cmd, 0}, socks5AddrBytes(addr)...)); err != nil {
+		return "", nil, err
+	}
+	bndAddr, err := readSocks5Reply(reader)
+	return bndAddr, reader, err
+}
+
+// bind 命令,监听端口等待服务器连接后转发,如ftp 的主动模式,serverAddr 为将要连接的服务器地址
+func (obj *Client) socks5BindHandle(ctx context.Context, client net.Conn, clientReader *bufio.Reader, serverAddr string, ip_addr string, key string) error {
+	var server net.Conn
+	var remoteAddr string
+	if ip_addr == "" {
+		bindIp, err := obj.bindIp(ctx, serverAddr)
+		if err != nil {
+			client.Write(socks5Reply(socks5Failure, ""))
+			return err
+		}
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: bindIp})
+		if err != nil {
+			client.Write(socks5Reply(socks5Failure, ""))
+			return err
+		}
+		defer listener.Close()
+		if _, err = client.Write(socks5Reply(socks5Succeeded, listener.Addr().String())); err != nil { //响应客户端监听的地址
+			return err
+		}
+		if server, err = acceptBind(listener, serverAddr); err != nil {
+			client.Write(socks5Reply(socks5Failure, ""))
+			return err
+		}
+		remoteAddr = server.RemoteAddr().String()
+	} else {
+		ipUrl, err := obj.verifyProxy(ip_addr)
+		if err != nil {
+			return err
+		}
+		if ipUrl.Scheme != "socks5" {
+			client.Write(socks5Reply(socks5CmdNotSupport, ""))
+			return errors.New("上游代理不支持bind:" + ipUrl.Scheme)
+		}
+		startTime := time.Now()
+		conn, reader, bndAddr, err := obj.socks5Upstream(ctx, ipUrl, socks5Bind, serverAddr)
+		obj.reportProxy(key, ip_addr, startTime, err)
+		if err != nil {
+			client.Write(socks5Reply(socks5Failure, ""))
+			return err
+		}
+		defer conn.Close()
+		if _, err = client.Write(socks5Reply(socks5Succeeded, bndAddr)); err != nil { //响应客户端上游代理监听的地址
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(socks5BindTimeout))
+		if remoteAddr, err = readSocks5Reply(reader); err != nil {
+			client.Write(socks5Reply(socks5Failure, ""))
+			return err
+		}
+		conn.SetReadDeadline(time.Time{})
+		server = &readerConn{Conn: conn, reader: reader}
+	}
+	defer server.Close()
+	if _, err := client.Write(socks5Reply(socks5Succeeded, remoteAddr)); err != nil { //响应客户端服务器已连接
+		return err
+	}
+	go func() { //服务端到客户端
+		defer server.Close()
+		defer client.Close()
+		io.Copy(client, server)
+	}()
+	_, err := io.Copy(server, clientReader) //客户端发送服务端
+	return err
+}
+
+// bind 监听的ip,服务器需要能连接到这个地址,使用连接服务器时的出口ip,
+// 没有设置出口ip 时使用到服务器的路由的本地ip,服务器地址未知时监听所有地址
+func (obj *Client) bindIp(ctx context.Context, serverAddr string) (net.IP, error) {
+	host, _, err := net.SplitHostPort(serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return nil, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	if localIp, err := obj.dialer.localIp(host, ips[0]); err != nil || localIp != nil {
+		return localIp, err
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(ips[0].String(), "80")) //udp 不会发送数据,只用于选择路由
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// 等待服务器连接,serverAddr 是ip 时只接受该ip 的连接
+func acceptBind(listener net.Listener, serverAddr string) (net.Conn, error) {
+	if tcpListener, ok := listener.(*net.TCPListener); ok {
+		tcpListener.SetDeadline(time.Now().Add(socks5BindTimeout))
+	}
+	serverHost, _, _ := net.SplitHostPort(serverAddr)
+	serverIp := net.ParseIP(serverHost)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if serverIp == nil || serverIp.IsUnspecified() {
+			return conn, nil
+		}
+		if remoteAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && remoteAddr.IP.Equal(serverIp) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// udp associate 命令,转发客户端的udp 数据包,客户端的tcp 连接关闭时结束
+func (obj *Client) socks5UdpHandle(ctx context.Context, client net.Conn, clientReader *bufio.Reader, ip_addr string, key string) error {
+	localHost, _, _ := net.SplitHostPort(client.LocalAddr().String())
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(localHost)})
+	if err != nil {
+		client.Write(socks5Reply(socks5Failure, ""))
+		return err
+	}
+	defer relay.Close()
+	var upstream *net.UDPAddr
+	if ip_addr != "" { //转发到上游socks5 代理的udp 端口
+		ipUrl, err := obj.verifyProxy(ip_addr)
+		if err != nil {
+			return err
+		}
+		if ipUrl.Scheme != "socks5" {
+			client.Write(socks5Reply(socks5CmdNotSupport, ""))
+			return errors.New("上游代理不支持udp:" + ipUrl.Scheme)
+		}
+		startTime := time.Now()
+		server, reader, bndAddr, err := obj.socks5Upstream(ctx, ipUrl, socks5Associate, "")
+		if err == nil {
+			upstream, err = net.ResolveUDPAddr("udp", bndAddr)
+		}
+		obj.reportProxy(key, ip_addr, startTime, err)
+		if err != nil {
+			if server != nil {
+				server.Close()
+			}
+			client.Write(socks5Reply(socks5Failure, ""))
+			return err
+		}
+		defer server.Close()
+		go func() { //上游代理的tcp 连接关闭时结束
+			io.Copy(io.Discard, reader)
+			relay.Close()
+		}()
+	}
+	if _, err = client.Write(socks5Reply(socks5Succeeded, relay.LocalAddr().String())); err != nil { //响应客户端udp 端口
+		return err
+	}
+	go func() { //客户端的tcp 连接关闭时结束
+		io.Copy(io.Discard, clientReader)
+		relay.Close()
+	}()
+	clientHost, _, _ := net.SplitHostPort(client.RemoteAddr().String())
+	udpRelay := &socks5UdpRelay{
+		client:   obj,
+		relay:    relay,
+		clientIp: net.ParseIP(clientHost),
+		upstream: upstream,
+		addrs:    make(map[string]*net.UDPAddr),
+	}
+	defer udpRelay.close()
+	err = udpRelay.run(ctx)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+type socks5UdpRelay struct {
+	client     *Client
+	relay      *net.UDPConn                //与客户端通信的udp 端口
+	clientIp   net.IP                      //只接受客户端ip 的数据包
+	clientAddr atomic.Pointer[net.UDPAddr] //客户端最后发送数据包的地址
+	upstream   *net.UDPAddr                //上游代理的udp 端口,为空时直接发送到服务器
+	servers    [2]*net.UDPConn             //发送到服务器的udp 端口,0:ipv4,1:ipv6
+	addrs      map[string]*net.UDPAddr     //域名解析缓存
+}
+
+func (obj *socks5UdpRelay) run(ctx context.Context) error {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := obj.relay.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		if obj.upstream != nil && from.Port == obj.upstream.Port && from.IP.Equal(obj.upstream.IP) { //上游代理返回的数据包,格式相同直接转发
+			if clientAddr := obj.clientAddr.Load(); clientAddr != nil {
+				obj.relay.WriteToUDP(buf[:n], clientAddr)
+			}
+			continue
+		}
+		if !from.IP.Equal(obj.clientIp) {
+			continue
+		}
+		obj.clientAddr.Store(from)
+		if obj.upstream != nil {
+			obj.relay.WriteToUDP(buf[:n], obj.upstream)
+			continue
+		}
+		if n < 4 || buf[2] != 0 { //不支持分片
+			continue
+		}
+		addr, addrLen, err := parseSocks5Addr(buf[3:n])
+		if err != nil {
+			continue
+		}
+		serverAddr, err := obj.resolve(ctx, addr)
+		if err != nil {
+			continue
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		server, err := obj.server(host, serverAddr.IP)
+		if err != nil {
+			continue
+		}
+		server.WriteToUDP(buf[3+addrLen:n], serverAddr)
+	}
+}
+
+// 解析数据包的目标地址,域名解析后缓存
+func (obj *socks5UdpRelay) resolve(ctx context.Context, addr string) (*net.UDPAddr, error) {
+	if serverAddr, ok := obj.addrs[addr]; ok {
+		return serverAddr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("dns 解析失败:" + host)
+	}
+	portNum, _ := strconv.Atoi(port)
+	serverAddr := &net.UDPAddr{IP: ips[0].IP, Port: portNum}
+	if len(obj.addrs) > 1024 {
+		obj.addrs = make(map[string]*net.UDPAddr)
+	}
+	obj.addrs[addr] = serverAddr
+	return serverAddr, nil
+}
+
+// 发送到服务器的udp 端口,每种ip 版本一个,接收服务器的数据包后转发给客户端
+func (obj *socks5UdpRelay) server(host string, ip net.IP) (*net.UDPConn, error) {
+	family := 0
+	network := "udp4"
+	if ip.To4() == nil {
+		family = 1
+		network = "udp6"
+	}
+	if obj.servers[family] != nil {
+		return obj.servers[family], nil
+	}
+	localIp, err := obj.client.dialer.localIp(host, ip)
+	if err != nil {
+		return nil, err
+	}
+	server, err := net.ListenUDP(network, &net.UDPAddr{IP: localIp})
+	if err != nil {
+		return nil, err
+	}
+	obj.servers[family] = server
+	go func() { //服务器到客户端
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			clientAddr := obj.clientAddr.Load()
+			if clientAddr == nil {
+				continue
+			}
+			packet := append(append([]byte{0, 0, 0}, socks5AddrBytes(from.String())...), buf[:n]...)
+			obj.relay.WriteToUDP(packet, clientAddr)
+		}
+	}()
+	return server, nil
+}
+func (obj *socks5UdpRelay) close() {
+	for _, server := range obj.servers {
+		if server != nil {
+			server.Close()
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 会话固定参数,相同会话的连接使用相同的上游代理
+type StickyOption struct {
+	Mode string        //会话的区分方式:user(代理用户名中的会话,如:usr-session-abc),ip(客户端ip),host(目标host),可以用逗号组合,如:ip,host,default:user
+	Ttl  time.Duration //会话最后一次使用后的保持时间,default:10min
+}
+
+type stickySession struct {
+	proxy  string
+	expire time.Time
+}
+type stickyStore struct {
+	modes     []string
+	ttl       time.Duration
+	sessions  map[string]*stickySession
+	clearTime time.Time
+	lock      sync.Mutex
+}
+
+func newStickyStore(option *StickyOption) (*stickyStore, error) {
+	if option.Mode == "" {
+		option.Mode = "user"
+	}
+	if option.Ttl == 0 {
+		option.Ttl = time.Minute * 10
+	}
+	obj := &stickyStore{
+		ttl:      option.Ttl,
+		sessions: make(map[string]*stickySession),
+	}
+	for _, mode := range strings.Split(option.Mode, ",") {
+		switch mode = strings.TrimSpace(mode); mode {
+		case "user", "ip", "host":
+			obj.modes = append(obj.modes, mode)
+		default:
+			return nil, errors.New("不支持的会话区分方式:" + mode)
+		}
+	}
+	return obj, nil
+}
+
+func (obj *stickyStore) hasMode(mode string) bool {
+	for _, m := range obj.modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// 会话的key,user 模式下没有会话时返回空
+func (obj *stickyStore) key(session string, clientIp string, host string) string {
+	keys := make([]string, len(obj.modes))
+	for i, mode := range obj.modes {
+		switch mode {
+		case "user":
+			if session == "" {
+				return ""
+			}
+			keys[i] = session
+		case "ip":
+			keys[i] = clientIp
+		case "host":
+			keys[i] = host
+		}
+	}
+	return strings.Join(keys, "@")
+}
+func (obj *stickyStore) get(key string) string {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	session, ok := obj.sessions[key]
+	if !ok {
+		return ""
+	}
+	now := time.Now()
+	if session.expire.Before(now) {
+		delete(obj.sessions, key)
+		return ""
+	}
+	session.expire = now.Add(obj.ttl)
+	return session.proxy
+}
+func (obj *stickyStore) set(key string, proxy string) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	now := time.Now()
+	if now.Sub(obj.clearTime) > obj.ttl { //清理过期的会话
+		for key, session := range obj.sessions {
+			if session.expire.Before(now) {
+				delete(obj.sessions, key)
+			}
+		}
+		obj.clearTime = now
+	}
+	obj.sessions[key] = &stickySession{proxy: proxy, expire: now.Add(obj.ttl)}
+}
+func (obj *stickyStore) del(key string) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	delete(obj.sessions, key)
+}
+
+// 拆分代理用户名中的会话,如:usr-session-abc 返回usr,abc
+func parseSessionUser(user string) (string, string) {
+	if strings.HasPrefix(user, "session-") {
+		return "", user[len("session-"):]
+	}
+	if i := strings.Index(user, "-session-"); i != -1 {
+		return user[:i], user[i+len("-session-"):]
+	}
+	return user, ""
+}
@@ -1,8 +1,10 @@
 package redis
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"sort"
 	"sync"
 
@@ -233,3 +235,32 @@ func (r *Client) GetOrderProxys(key string) ([]string, error) {
 	r.proxys[key] = results
 	return results, nil
 }
+
+// cookie 存储,可用于requests.NewJar
+type CookieStore struct {
+	client *Client
+	key    string
+}
+
+// 创建cookie 存储,cookie 以json 保存在key 中
+func (r *Client) NewCookieStore(key string) *CookieStore {
+	return &CookieStore{client: r, key: key}
+}
+func (obj *CookieStore) Load() ([]*http.Cookie, error) {
+	cookies := []*http.Cookie{}
+	val, err := obj.client.object.Get(obj.key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return cookies, nil
+		}
+		return cookies, err
+	}
+	return cookies, json.Unmarshal(tools.StringToBytes(val), &cookies)
+}
+func (obj *CookieStore) Save(cookies []*http.Cookie) error {
+	con, err := json.Marshal(cookies)
+	if err != nil {
+		return err
+	}
+	return obj.client.object.Set(obj.key, tools.BytesToString(con), 0).Err()
+}
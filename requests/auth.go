@@ -0,0 +1,392 @@
+package requests
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 认证,每次请求前调用Sign 添加认证信息
+//
+// 返回401 时调用Challenge,返回true 时重新认证后重试一次,不计入重试次数
+type Auth interface {
+	Sign(ctx context.Context, req *SignRequest) error
+	Challenge(resp *Response) bool
+}
+
+// 认证中间件,在所有中间件之后执行,保证签名的是最终的请求
+func authMiddleware(auth Auth) Middleware {
+	return signMiddleware(auth.Sign)
+}
+
+// basic 认证
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (obj *BasicAuth) Sign(ctx context.Context, req *SignRequest) error {
+	req.Headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(obj.Username+":"+obj.Password)))
+	return nil
+}
+func (obj *BasicAuth) Challenge(resp *Response) bool {
+	return false
+}
+
+// digest 认证,支持MD5,MD5-sess,SHA-256,SHA-256-sess,qop 支持auth,auth-int
+type DigestAuth struct {
+	Username string
+	Password string
+
+	lock      sync.Mutex
+	challenge map[string]string
+	nc        int
+}
+
+// 解析www-authenticate 中的参数
+func parseAuthParams(value string) map[string]string {
+	params := map[string]string{}
+	for value != "" {
+		value = strings.TrimLeft(value, " ,")
+		key, rest, ok := strings.Cut(value, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			var builder strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				builder.WriteByte(rest[i])
+			}
+			val = builder.String()
+			if i < len(rest) {
+				i++
+			}
+			value = rest[i:]
+		} else {
+			val, value, _ = strings.Cut(rest, ",")
+			val = strings.TrimSpace(val)
+		}
+		params[key] = val
+	}
+	return params
+}
+func (obj *DigestAuth) Challenge(resp *Response) bool {
+	for _, value := range resp.Headers().Values("Www-Authenticate") {
+		scheme, params, _ := strings.Cut(value, " ")
+		if !strings.EqualFold(scheme, "digest") {
+			continue
+		}
+		obj.lock.Lock()
+		obj.challenge = parseAuthParams(params)
+		obj.nc = 0
+		obj.lock.Unlock()
+		return true
+	}
+	return false
+}
+func (obj *DigestAuth) Sign(ctx context.Context, req *SignRequest) error {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if obj.challenge == nil { //收到401 后才能计算
+		return nil
+	}
+	algorithm := obj.challenge["algorithm"]
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return errors.New("不支持的digest 算法:" + algorithm)
+	}
+	h := func(val string) string {
+		hs := newHash()
+		hs.Write([]byte(val))
+		return hex.EncodeToString(hs.Sum(nil))
+	}
+	var qop string
+	for _, val := range strings.Split(obj.challenge["qop"], ",") {
+		val = strings.TrimSpace(val)
+		if val == "auth" || (val == "auth-int" && qop == "") {
+			qop = val
+		}
+	}
+	nonce := obj.challenge["nonce"]
+	obj.nc++
+	nc := fmt.Sprintf("%08x", obj.nc)
+	cnonceBytes := make([]byte, 8)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	uri := req.Url.RequestURI()
+	ha1 := h(obj.Username + ":" + obj.challenge["realm"] + ":" + obj.Password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+	if qop == "auth-int" {
+		ha2 = h(req.Method + ":" + uri + ":" + h(string(req.Body)))
+	}
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+	authValue := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`, obj.Username, obj.challenge["realm"], nonce, uri, response)
+	if algorithm != "" {
+		authValue += ", algorithm=" + algorithm
+	}
+	if opaque, ok := obj.challenge["opaque"]; ok {
+		authValue += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	if qop != "" {
+		authValue += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	req.Headers.Set("Authorization", authValue)
+	return nil
+}
+
+// oauth2 认证,支持client_credentials 与refresh_token 模式,token 过期或401 时自动刷新
+type OAuth2Auth struct {
+	TokenUrl     string   //获取token 的地址
+	ClientId     string   //client_id
+	ClientSecret string   //client_secret
+	Scopes       []string //scope
+	RefreshToken string   //设置后使用refresh_token 模式,否则使用client_credentials 模式
+	AccessToken  string   //初始的token,为空时自动获取
+	BasicAuth    bool     //client_id,client_secret 使用basic 认证发送,default:放在表单中
+	Client       *Client  //获取token 的client,default:新建client
+
+	lock       sync.Mutex
+	expires    time.Time
+	refreshing chan struct{} //正在获取token 时不为nil,获取完成后关闭
+	refreshErr error         //最后一次获取token 的错误
+}
+
+func (obj *OAuth2Auth) Sign(ctx context.Context, req *SignRequest) error {
+	obj.lock.Lock()
+	if obj.AccessToken != "" && (obj.expires.IsZero() || time.Now().Add(time.Second*10).Before(obj.expires)) {
+		token := obj.AccessToken
+		obj.lock.Unlock()
+		req.Headers.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if refreshing := obj.refreshing; refreshing != nil { //其它请求正在获取token,等待结果
+		obj.lock.Unlock()
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		obj.lock.Lock()
+		token, err := obj.AccessToken, obj.refreshErr
+		obj.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		req.Headers.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if obj.Client == nil {
+		client, err := NewClient(nil)
+		if err != nil {
+			obj.lock.Unlock()
+			return err
+		}
+		obj.Client = client
+	}
+	refreshing := make(chan struct{})
+	obj.refreshing = refreshing
+	client, refreshToken := obj.Client, obj.RefreshToken
+	obj.lock.Unlock()
+	token, refreshToken, expires, err := obj.refresh(ctx, client, refreshToken) //获取token 时不持有lock
+	obj.lock.Lock()
+	if err == nil {
+		obj.AccessToken, obj.RefreshToken, obj.expires = token, refreshToken, expires
+	}
+	obj.refreshErr = err
+	obj.refreshing = nil
+	close(refreshing)
+	obj.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	req.Headers.Set("Authorization", "Bearer "+token)
+	return nil
+}
+func (obj *OAuth2Auth) Challenge(resp *Response) bool {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.AccessToken = ""
+	return true
+}
+
+// 获取新的token,返回access_token,refresh_token,过期时间
+func (obj *OAuth2Auth) refresh(ctx context.Context, client *Client, refreshToken string) (string, string, time.Time, error) {
+	form := url.Values{}
+	if refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(obj.Scopes) > 0 {
+		form.Set("scope", strings.Join(obj.Scopes, " "))
+	}
+	headers := map[string]string{"Accept": "application/json"}
+	if obj.BasicAuth {
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(obj.ClientId)+":"+url.QueryEscape(obj.ClientSecret)))
+	} else {
+		form.Set("client_id", obj.ClientId)
+		form.Set("client_secret", obj.ClientSecret)
+	}
+	resp, err := client.Request(ctx, "post", obj.TokenUrl, RequestOption{Data: form.Encode(), Headers: headers, DisCache: true})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	jsonData := resp.Json()
+	if resp.StatusCode() != 200 || jsonData.Get("access_token").String() == "" {
+		return "", "", time.Time{}, fmt.Errorf("获取token 失败,状态码:%d,错误:%s %s", resp.StatusCode(), jsonData.Get("error").String(), jsonData.Get("error_description").String())
+	}
+	if val := jsonData.Get("refresh_token").String(); val != "" {
+		refreshToken = val
+	}
+	var expires time.Time
+	if expiresIn := jsonData.Get("expires_in").Int(); expiresIn > 0 {
+		expires = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return jsonData.Get("access_token").String(), refreshToken, expires, nil
+}
+
+// aws sigv4 签名,可用于s3 兼容的接口
+type AwsAuth struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string //临时凭证的token
+	Region       string //default:us-east-1
+	Service      string //default:s3
+}
+
+func (obj *AwsAuth) Sign(ctx context.Context, req *SignRequest) error {
+	return obj.sign(req, time.Now().UTC())
+}
+func (obj *AwsAuth) Challenge(resp *Response) bool {
+	return false
+}
+
+// uri 编码,只保留rfc3986 的非保留字符
+func awsEscape(val string) string {
+	var builder strings.Builder
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			builder.WriteByte(c)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return builder.String()
+}
+func awsHmac(key []byte, val string) []byte {
+	hs := hmac.New(sha256.New, key)
+	hs.Write([]byte(val))
+	return hs.Sum(nil)
+}
+func (obj *AwsAuth) sign(req *SignRequest, now time.Time) error {
+	region := obj.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	service := obj.Service
+	if service == "" {
+		service = "s3"
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(req.Body)
+	req.Headers.Set("X-Amz-Date", amzDate)
+	req.Headers.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if obj.SessionToken != "" {
+		req.Headers.Set("X-Amz-Security-Token", obj.SessionToken)
+	}
+	//path 的每一段编码,s3 之外的服务需要编码两次
+	segments := strings.Split(req.Url.Path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+		if service != "s3" {
+			segments[i] = awsEscape(segments[i])
+		}
+	}
+	canonicalUri := strings.Join(segments, "/")
+	if canonicalUri == "" {
+		canonicalUri = "/"
+	}
+	query := []string{}
+	for key, vals := range req.Url.Query() {
+		for _, val := range vals {
+			query = append(query, awsEscape(key)+"="+awsEscape(val))
+		}
+	}
+	sort.Strings(query)
+	//签名host,content-type,content-md5,range,x-amz-* 请求头
+	host := req.Headers.Get("Host")
+	if host == "" {
+		host = req.Url.Host
+	}
+	headers := map[string]string{"host": host}
+	for key, vals := range req.Headers {
+		key = strings.ToLower(key)
+		if key == "content-type" || key == "content-md5" || key == "range" || strings.HasPrefix(key, "x-amz-") {
+			trimVals := make([]string, len(vals))
+			for i, val := range vals {
+				trimVals[i] = strings.Join(strings.Fields(val), " ")
+			}
+			headers[key] = strings.Join(trimVals, ",")
+		}
+	}
+	headerNames := make([]string, 0, len(headers))
+	for key := range headers {
+		headerNames = append(headerNames, key)
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, key := range headerNames {
+		canonicalHeaders.WriteString(key + ":" + headers[key] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalUri,
+		strings.Join(query, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signingKey := awsHmac(awsHmac(awsHmac(awsHmac([]byte("AWS4"+obj.SecretKey), date), region), service), "aws4_request")
+	signature := hex.EncodeToString(awsHmac(signingKey, stringToSign))
+	req.Headers.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", obj.AccessKey, scope, signedHeaders, signature))
+	return nil
+}
@@ -0,0 +1,495 @@
+package requests
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/tools"
+	"github.com/xujiajun/nutsdb"
+)
+
+// 缓存的响应
+type CacheData struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Vary       map[string]string //vary 请求头的值
+	Time       time.Time         //缓存时间
+	Expires    time.Time         //新鲜度过期时间,过期后需要验证
+}
+
+// 缓存存储
+type CacheStore interface {
+	Get(key string) (*CacheData, error)                       //没有缓存时返回nil,nil
+	Put(key string, data *CacheData, ttl time.Duration) error //ttl 为0 时不过期
+	Delete(key string) error
+}
+
+type CacheOption struct {
+	Store       CacheStore //缓存存储,default:内存
+	ForceTime   int64      //强制缓存时间(秒),忽略Cache-Control,开发调试用
+	MaxBodySize int64      //可缓存的最大响应体,default:10M
+}
+
+// 验证器存在时,过期的缓存继续保存的时间
+var cacheKeepTime = time.Hour * 24 * 7
+
+const cacheHeaderKey = "X-From-Cache"
+
+// 解析Cache-Control
+func parseCacheControl(header http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, item := range strings.Split(value, ",") {
+			key, val, _ := strings.Cut(strings.TrimSpace(item), "=")
+			if key != "" {
+				cc[strings.ToLower(key)] = strings.Trim(val, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// 可以缓存的状态码
+var cacheStatusCodes = map[int]bool{200: true, 203: true, 204: true, 300: true, 301: true, 308: true, 404: true, 405: true, 410: true, 414: true, 501: true}
+
+// 响应的新鲜度过期时间,ok 为false 时不能缓存
+func cacheExpires(resp *http.Response, now time.Time) (time.Time, bool) {
+	if !cacheStatusCodes[resp.StatusCode] {
+		return now, false
+	}
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return now, false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return now, false
+	}
+	hasValidator := resp.Header.Get("Etag") != "" || resp.Header.Get("Last-Modified") != ""
+	if _, ok := cc["no-cache"]; ok {
+		return now, hasValidator
+	}
+	var age time.Duration
+	if val, err := strconv.ParseInt(resp.Header.Get("Age"), 10, 64); err == nil {
+		age = time.Duration(val) * time.Second
+	}
+	if val, ok := cc["max-age"]; ok {
+		maxAge, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return now, hasValidator
+		}
+		return now.Add(time.Duration(maxAge)*time.Second - age), true
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+	if val := resp.Header.Get("Expires"); val != "" {
+		expires, err := http.ParseTime(val)
+		if err != nil {
+			return now, hasValidator
+		}
+		return now.Add(expires.Sub(date) - age), true
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && date.After(lastModified) { //启发式新鲜度
+		return now.Add(date.Sub(lastModified)/10 - age), true
+	}
+	return now, hasValidator
+}
+
+type cacheTransport struct {
+	transport http.RoundTripper
+	option    CacheOption
+}
+
+func (obj *cacheTransport) key(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
+func (obj *cacheTransport) varyMatch(req *http.Request, data *CacheData) bool {
+	for key, val := range data.Vary {
+		if req.Header.Get(key) != val {
+			return false
+		}
+	}
+	return true
+}
+func (obj *cacheTransport) cacheResponse(req *http.Request, data *CacheData) *http.Response {
+	header := data.Header.Clone()
+	header.Set(cacheHeaderKey, "1")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", data.StatusCode, http.StatusText(data.StatusCode)),
+		StatusCode:    data.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data.Body)),
+		ContentLength: int64(len(data.Body)),
+		Request:       req,
+	}
+}
+
+// 保存缓存
+func (obj *cacheTransport) put(key string, data *CacheData) {
+	var ttl time.Duration
+	if obj.option.ForceTime > 0 {
+		ttl = time.Duration(obj.option.ForceTime) * time.Second
+	} else if data.Header.Get("Etag") != "" || data.Header.Get("Last-Modified") != "" {
+		ttl = time.Until(data.Expires) + cacheKeepTime
+	} else {
+		ttl = time.Until(data.Expires)
+	}
+	if ttl > 0 {
+		obj.option.Store.Put(key, data, ttl)
+	}
+}
+func (obj *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return obj.transport.RoundTrip(req)
+	}
+	reqCc := parseCacheControl(req.Header)
+	if _, ok := reqCc["no-store"]; ok {
+		return obj.transport.RoundTrip(req)
+	}
+	key := obj.key(req)
+	now := time.Now()
+	data, err := obj.option.Store.Get(key)
+	if err != nil || (data != nil && !obj.varyMatch(req, data)) {
+		data = nil
+	}
+	if data != nil {
+		if obj.option.ForceTime > 0 {
+			if now.Before(data.Time.Add(time.Duration(obj.option.ForceTime) * time.Second)) {
+				return obj.cacheResponse(req, data), nil
+			}
+			data = nil
+		} else {
+			_, noCache := reqCc["no-cache"]
+			if !noCache && reqCc["max-age"] != "0" && now.Before(data.Expires) {
+				return obj.cacheResponse(req, data), nil
+			}
+			//验证缓存
+			etag := data.Header.Get("Etag")
+			lastModified := data.Header.Get("Last-Modified")
+			if etag == "" && lastModified == "" {
+				data = nil
+			} else {
+				req = req.Clone(req.Context())
+				if etag != "" {
+					req.Header.Set("If-None-Match", etag)
+				}
+				if lastModified != "" {
+					req.Header.Set("If-Modified-Since", lastModified)
+				}
+			}
+		}
+	}
+	resp, err := obj.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	now = time.Now()
+	if resp.StatusCode == http.StatusNotModified && data != nil { //缓存验证通过
+		resp.Body.Close()
+		for kk, vv := range resp.Header {
+			switch kk {
+			case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Set-Cookie":
+			default:
+				data.Header[kk] = vv
+			}
+		}
+		data.Time = now
+		if data.Expires, _ = cacheExpires(&http.Response{StatusCode: data.StatusCode, Header: data.Header}, now); !data.Expires.After(now) {
+			data.Expires = now
+		}
+		obj.put(key, data)
+		return obj.cacheResponse(req, data), nil
+	}
+	var expires time.Time
+	if obj.option.ForceTime > 0 {
+		if resp.StatusCode >= 500 {
+			return resp, err
+		}
+		expires = now.Add(time.Duration(obj.option.ForceTime) * time.Second)
+	} else {
+		var ok bool
+		if expires, ok = cacheExpires(resp, now); !ok {
+			return resp, err
+		}
+	}
+	if resp.ContentLength > obj.option.MaxBodySize {
+		return resp, err
+	}
+	newData := &CacheData{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Vary:       map[string]string{},
+		Time:       now,
+		Expires:    expires,
+	}
+	newData.Header.Del("Set-Cookie")
+	for _, value := range resp.Header.Values("Vary") {
+		for _, key := range strings.Split(value, ",") {
+			if key = http.CanonicalHeaderKey(strings.TrimSpace(key)); key != "" {
+				newData.Vary[key] = req.Header.Get(key)
+			}
+		}
+	}
+	resp.Body = &cacheBody{
+		body:    resp.Body,
+		maxSize: obj.option.MaxBodySize,
+		callBack: func(body []byte) {
+			newData.Body = body
+			obj.put(key, newData)
+		},
+	}
+	return resp, err
+}
+
+// 读取完成后保存缓存的响应体
+type cacheBody struct {
+	body     io.ReadCloser
+	buf      bytes.Buffer
+	maxSize  int64
+	over     bool
+	callBack func([]byte)
+}
+
+func (obj *cacheBody) Read(p []byte) (int, error) {
+	n, err := obj.body.Read(p)
+	if !obj.over {
+		if int64(obj.buf.Len()+n) > obj.maxSize {
+			obj.over = true
+			obj.buf = bytes.Buffer{}
+		} else {
+			obj.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !obj.over {
+		obj.over = true
+		obj.callBack(obj.buf.Bytes())
+	}
+	return n, err
+}
+func (obj *cacheBody) Close() error {
+	return obj.body.Close()
+}
+
+// 内存缓存的参数
+type MemoryCacheOption struct {
+	MaxEntries int   //最多缓存的响应数,超过时删除最久没有使用的,default:10000
+	MaxSize    int64 //缓存的响应体总大小,超过时删除最久没有使用的,default:256M
+}
+
+// 内存缓存,按最近使用淘汰,定期清理过期的缓存
+type MemoryCacheStore struct {
+	lock      sync.Mutex
+	option    MemoryCacheOption
+	datas     map[string]*list.Element
+	lru       *list.List //最近使用的在前面
+	size      int64      //响应体总大小
+	clearTime time.Time  //上次清理过期缓存的时间
+}
+type memoryCacheData struct {
+	key    string
+	data   *CacheData
+	expire time.Time
+}
+
+// 清理过期缓存的间隔
+const memoryCacheClearTime = time.Minute
+
+func NewMemoryCacheStore(options ...MemoryCacheOption) *MemoryCacheStore {
+	var option MemoryCacheOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.MaxEntries <= 0 {
+		option.MaxEntries = 10000
+	}
+	if option.MaxSize <= 0 {
+		option.MaxSize = 256 * 1024 * 1024
+	}
+	return &MemoryCacheStore{option: option, datas: map[string]*list.Element{}, lru: list.New(), clearTime: time.Now()}
+}
+func (obj *MemoryCacheStore) Get(key string) (*CacheData, error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	elem, ok := obj.datas[key]
+	if !ok {
+		return nil, nil
+	}
+	val := elem.Value.(*memoryCacheData)
+	if !val.expire.IsZero() && time.Now().After(val.expire) {
+		obj.remove(elem)
+		return nil, nil
+	}
+	obj.lru.MoveToFront(elem)
+	data := *val.data
+	data.Header = data.Header.Clone()
+	return &data, nil
+}
+func (obj *MemoryCacheStore) Put(key string, data *CacheData, ttl time.Duration) error {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if elem, ok := obj.datas[key]; ok {
+		obj.remove(elem)
+	}
+	now := time.Now()
+	if now.Sub(obj.clearTime) > memoryCacheClearTime { //清理过期的缓存
+		for elem := obj.lru.Front(); elem != nil; {
+			next := elem.Next()
+			if val := elem.Value.(*memoryCacheData); !val.expire.IsZero() && now.After(val.expire) {
+				obj.remove(elem)
+			}
+			elem = next
+		}
+		obj.clearTime = now
+	}
+	if int64(len(data.Body)) > obj.option.MaxSize {
+		return nil
+	}
+	val := &memoryCacheData{key: key, data: data}
+	if ttl > 0 {
+		val.expire = now.Add(ttl)
+	}
+	obj.datas[key] = obj.lru.PushFront(val)
+	obj.size += int64(len(data.Body))
+	for obj.lru.Len() > obj.option.MaxEntries || obj.size > obj.option.MaxSize { //删除最久没有使用的
+		obj.remove(obj.lru.Back())
+	}
+	return nil
+}
+func (obj *MemoryCacheStore) Delete(key string) error {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if elem, ok := obj.datas[key]; ok {
+		obj.remove(elem)
+	}
+	return nil
+}
+
+// 删除缓存,必须持有lock
+func (obj *MemoryCacheStore) remove(elem *list.Element) {
+	val := obj.lru.Remove(elem).(*memoryCacheData)
+	delete(obj.datas, val.key)
+	obj.size -= int64(len(val.data.Body))
+}
+
+type fileCacheData struct {
+	Data   *CacheData
+	Expire time.Time
+}
+
+// 磁盘缓存,每个响应一个文件
+type DiskCacheStore struct {
+	dir string
+}
+
+func NewDiskCacheStore(dir string) (*DiskCacheStore, error) {
+	return &DiskCacheStore{dir: dir}, os.MkdirAll(dir, 0755)
+}
+func (obj *DiskCacheStore) path(key string) string {
+	return filepath.Join(obj.dir, tools.Hex(tools.Md5(key)))
+}
+func (obj *DiskCacheStore) Get(key string) (*CacheData, error) {
+	con, err := os.ReadFile(obj.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var val fileCacheData
+	if err = gob.NewDecoder(bytes.NewReader(con)).Decode(&val); err != nil {
+		return nil, err
+	}
+	if !val.Expire.IsZero() && time.Now().After(val.Expire) {
+		os.Remove(obj.path(key))
+		return nil, nil
+	}
+	return val.Data, nil
+}
+func (obj *DiskCacheStore) Put(key string, data *CacheData, ttl time.Duration) error {
+	val := fileCacheData{Data: data}
+	if ttl > 0 {
+		val.Expire = time.Now().Add(ttl)
+	}
+	con := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(con).Encode(val); err != nil {
+		return err
+	}
+	return os.WriteFile(obj.path(key), con.Bytes(), 0644)
+}
+func (obj *DiskCacheStore) Delete(key string) error {
+	err := os.Remove(obj.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// nutsdb 缓存
+type NutsdbCacheStore struct {
+	db   *nutsdb.DB
+	name string
+}
+
+func NewNutsdbCacheStore(dir string) (*NutsdbCacheStore, error) {
+	option := nutsdb.DefaultOptions
+	option.EntryIdxMode = nutsdb.HintKeyAndRAMIdxMode
+	db, err := nutsdb.Open(
+		option,
+		nutsdb.WithDir(dir),
+	)
+	return &NutsdbCacheStore{db: db, name: "requestsCache"}, err
+}
+func (obj *NutsdbCacheStore) Close() error {
+	return obj.db.Close()
+}
+func (obj *NutsdbCacheStore) Get(key string) (*CacheData, error) {
+	var data *CacheData
+	err := obj.db.View(
+		func(tx *nutsdb.Tx) error {
+			e, err := tx.Get(obj.name, tools.StringToBytes(key))
+			if err != nil || e == nil { //没有缓存
+				return nil
+			}
+			data = &CacheData{}
+			return gob.NewDecoder(bytes.NewReader(e.Value)).Decode(data)
+		})
+	return data, err
+}
+func (obj *NutsdbCacheStore) Put(key string, data *CacheData, ttl time.Duration) error {
+	return obj.db.Update(
+		func(tx *nutsdb.Tx) error {
+			con := bytes.NewBuffer(nil)
+			if err := gob.NewEncoder(con).Encode(data); err != nil {
+				return err
+			}
+			var seconds uint32
+			if ttl > 0 {
+				if seconds = uint32(ttl / time.Second); seconds == 0 {
+					seconds = 1
+				}
+			}
+			return tx.Put(obj.name, []byte(key), con.Bytes(), seconds)
+		})
+}
+func (obj *NutsdbCacheStore) Delete(key string) error {
+	return obj.db.Update(
+		func(tx *nutsdb.Tx) error {
+			return tx.Delete(obj.name, []byte(key))
+		})
+}
@@ -3,49 +3,81 @@ package requests
 import (
 	"context"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
-
-	"golang.org/x/net/http2"
-	"golang.org/x/net/publicsuffix"
+	"strings"
+	"sync"
 )
 
 type ClientOption struct {
 	GetProxy              func(ctx context.Context, url *url.URL) (string, error)
 	Proxy                 string
-	TLSHandshakeTimeout   int64  //tls 超时时间,default:15
-	ResponseHeaderTimeout int64  //第一个response headers 接收超时时间,default:30
-	DisCookie             bool   //关闭cookies管理
-	DisAlive              bool   //关闭长连接
-	DisCompression        bool   //关闭请求头中的压缩功能
-	LocalAddr             string //本地网卡出口ip
-	IdleConnTimeout       int64  //空闲连接在连接池中的超时时间,default:30
-	KeepAlive             int64  //keepalive保活检测定时,default:15
-	DnsCacheTime          int64  //dns解析缓存时间60*30
+	TLSHandshakeTimeout   int64                //tls 超时时间,default:15
+	ResponseHeaderTimeout int64                //第一个response headers 接收超时时间,default:30
+	DisCookie             bool                 //关闭cookies管理
+	CookieJar             CookieJar            //自定义cookie jar,default:内存中的Jar
+	Cache                 *CacheOption         //响应缓存,只缓存get 请求
+	DisAlive              bool                 //关闭长连接
+	DisCompression        bool                 //关闭请求头中的压缩功能
+	LocalAddr             string               //本地网卡出口ip
+	LocalAddrs            *LocalAddrOption     //本地网卡出口ip 池,轮询,随机,按host 固定,设置后LocalAddr 无效
+	IdleConnTimeout       int64                //空闲连接在连接池中的超时时间,default:30
+	KeepAlive             int64                //keepalive保活检测定时,default:15
+	DnsCacheTime          int64                //dns解析缓存时间60*30
+	Resolver              *ResolverOption      //自定义dns 解析,doh,dot,指定dns 服务器,固定解析,ipv4/ipv6 优先
+	Tls                   *TlsOption           //验证服务器证书,公钥固定,客户端证书
+	Ja3Spec               Ja3Spec              //ja3指纹,设置后自动开启ja3
+	H2Ja3Spec             H2Ja3Spec            //http2指纹,不设置时使用与ja3指纹配套的http2指纹
+	Profile               *Profile             //浏览器指纹,设置请求头,请求头顺序,ja3指纹,http2指纹,可以通过GetProfile,RandomProfile 生成
+	HostLimit             HostLimit            //每个host 默认的请求限制
+	HostLimits            map[string]HostLimit //指定host 的请求限制,key:host
+	HarRecorder           *HarRecorder         //记录所有请求与响应,可保存为har 文件
+	HarReplay             *Har                 //从har 中返回响应,不请求网络
 }
 type Client struct {
 	RedirectNum   int                                       //重定向次数
 	DisDecode     bool                                      //关闭自动编码
+	CharsetHook   func(*Response) string                    //修改自动检测的编码,Response.Charset() 为检测结果,返回空时使用检测结果
 	DisRead       bool                                      //关闭默认读取请求体
 	DisUnZip      bool                                      //变比自动解压
 	TryNum        int64                                     //重试次数
+	RetryPolicy   *RetryPolicy                              //重试策略
+	Auth          Auth                                      //认证
 	BeforCallBack func(*RequestOption)                      //请求前回调的方法
 	AfterCallBack func(*RequestOption, *Response) *Response //请求后回调的方法
 	Timeout       int64                                     //请求超时时间
 	Http2         bool                                      //开启http2 transport
+	Http3         bool                                      //开启http3 transport
+	AutoHttp3     bool                                      //收到alt-svc h3 响应头后自动使用http3
 	Ja3           bool                                      //开启ja3
 
-	Headers map[string]string //请求头
-	Bar     bool              //是否开启bar
+	Headers            map[string]string //请求头
+	OrderHeaders       []string          //请求头顺序,http1.1 与http2 都按此顺序发送,不区分大小写
+	OriginalHeaderCase bool              //http1.1 保持请求头原始的大小写
+	Bar                bool              //是否开启bar
 
-	disCookie bool //关闭cookies管理
-	disAlive  bool //关闭长连接
+	disCookie      bool      //关闭cookies管理
+	disAlive       bool      //关闭长连接
+	ja3Spec        Ja3Spec   //ja3指纹
+	h2Ja3Spec      H2Ja3Spec //http2指纹
+	profile        *Profile  //浏览器指纹
+	proxy          string    //代理
+	getProxy       func(ctx context.Context, url *url.URL) (string, error)
+	limiter        *limiter //host 请求限制
+	jar            CookieJar
+	cache          *CacheOption //响应缓存
+	middlewares    []Middleware //client 中间件,写时复制,middlewareLock
+	middlewareLock sync.Mutex
+	har            *HarRecorder
+	harReplay      *harReplayTransport
 
 	client        *http.Client
 	baseTransport *http.Transport
 
 	client2        *http.Client
-	baseTransport2 *http2.Transport
+	baseTransport2 *http2Transport
+
+	client3        *http.Client
+	baseTransport3 *http3Transport
 
 	ctx context.Context
 	cnl context.CancelFunc
@@ -76,6 +108,14 @@ func NewClient(preCtx context.Context, client_optinos ...ClientOption) (*Client,
 	if session_option.DnsCacheTime == 0 {
 		session_option.DnsCacheTime = 60 * 30
 	}
+	if session_option.Profile != nil {
+		if !session_option.Ja3Spec.IsSet() {
+			session_option.Ja3Spec = session_option.Profile.Ja3Spec
+		}
+		if !session_option.H2Ja3Spec.IsSet() {
+			session_option.H2Ja3Spec = session_option.Profile.H2Ja3Spec
+		}
+	}
 	dialClient, err := newDail(ctx, session_option)
 	if err != nil {
 		cnl()
@@ -83,27 +123,49 @@ func NewClient(preCtx context.Context, client_optinos ...ClientOption) (*Client,
 	}
 	var client http.Client
 	var client2 http.Client
+	var client3 http.Client
 	//创建cookiesjar
-	var jar *cookiejar.Jar
+	var jar CookieJar
 	if !session_option.DisCookie {
-		if jar, err = cookiejar.New(nil); err != nil {
+		if session_option.CookieJar != nil {
+			jar = session_option.CookieJar
+		} else if jar, err = NewJar(); err != nil {
 			cnl()
 			return nil, err
 		}
 	}
+	var cache *CacheOption
+	if session_option.Cache != nil {
+		cache = &CacheOption{}
+		*cache = *session_option.Cache
+		if cache.Store == nil {
+			cache.Store = NewMemoryCacheStore()
+		}
+		if cache.MaxBodySize == 0 {
+			cache.MaxBodySize = 10 * 1024 * 1024
+		}
+	}
+	var harReplay *harReplayTransport
+	if session_option.HarReplay != nil {
+		harReplay = newHarReplayTransport(session_option.HarReplay)
+	}
 	baseTransport := newHttpTransport(ctx, session_option, dialClient)
 	baseTransport2 := newHttp2Transport(ctx, session_option, dialClient)
 
 	client.Transport = baseTransport.Clone()
-	client2.Transport = cloneTransport(baseTransport2)
+	client2.Transport = baseTransport2
+	baseTransport3 := newHttp3Transport(ctx, session_option, dialClient, client.Transport)
+	client3.Transport = baseTransport3
 
 	client.Jar = jar
 	client2.Jar = jar
+	client3.Jar = jar
 
 	client.CheckRedirect = checkRedirect
 	client2.CheckRedirect = checkRedirect
+	client3.CheckRedirect = checkRedirect
 
-	return &Client{ctx: ctx, cnl: cnl, client: &client, baseTransport: &baseTransport, client2: &client2, baseTransport2: baseTransport2, disAlive: session_option.DisAlive, disCookie: session_option.DisCookie}, nil
+	return &Client{ctx: ctx, cnl: cnl, client: &client, baseTransport: &baseTransport, client2: &client2, baseTransport2: baseTransport2, client3: &client3, baseTransport3: baseTransport3, disAlive: session_option.DisAlive, disCookie: session_option.DisCookie, ja3Spec: session_option.Ja3Spec, h2Ja3Spec: session_option.H2Ja3Spec, profile: session_option.Profile, proxy: session_option.Proxy, getProxy: session_option.GetProxy, limiter: newLimiter(session_option), jar: jar, cache: cache, har: session_option.HarRecorder, harReplay: harReplay}, nil
 }
 func checkRedirect(req *http.Request, via []*http.Request) error {
 	ctxData := req.Context().Value(keyPrincipalID).(*reqCtxData)
@@ -113,6 +175,14 @@ func checkRedirect(req *http.Request, via []*http.Request) error {
 	}
 	return http.ErrUseLastResponse
 }
+
+// http1.1 的连接池不区分代理,与client 代理不同的请求不能复用连接
+func (obj *Client) proxyChanged(request_option RequestOption) bool {
+	if request_option.DisProxy {
+		return obj.proxy != "" || obj.getProxy != nil
+	}
+	return request_option.Proxy != "" && request_option.Proxy != obj.proxy
+}
 func (obj *Client) clone(request_option RequestOption) *http.Client {
 	cli := &http.Client{
 		CheckRedirect: obj.client.CheckRedirect,
@@ -120,11 +190,22 @@ func (obj *Client) clone(request_option RequestOption) *http.Client {
 	if !request_option.DisCookie && obj.client.Jar != nil {
 		cli.Jar = obj.client.Jar
 	}
-	if request_option.Http2 {
+	if request_option.Http3 {
+		if !request_option.DisAlive {
+			cli.Transport = obj.client3.Transport
+		} else {
+			transport := obj.baseTransport3.clone()
+			transport.disAlive = true
+			transport.fallback = obj.baseTransport.Clone() //不自动升级的请求也不复用连接
+			cli.Transport = transport
+		}
+	} else if request_option.Http2 {
 		if !request_option.DisAlive {
 			cli.Transport = obj.client2.Transport
 		} else {
-			cli.Transport = cloneTransport(obj.baseTransport2)
+			transport := obj.baseTransport2.clone()
+			transport.disAlive = true
+			cli.Transport = transport
 		}
 	} else {
 		if !request_option.DisAlive {
@@ -136,25 +217,25 @@ func (obj *Client) clone(request_option RequestOption) *http.Client {
 	return cli
 }
 func (obj *Client) Reset() error {
-	if obj.client.Jar != nil {
-		jar, err := cookiejar.New(&cookiejar.Options{
-			PublicSuffixList: publicsuffix.List,
-		})
-		if err != nil {
-			return err
-		}
-		obj.client.Jar = jar
+	if err := obj.ClearCookies(); err != nil {
+		return err
 	}
 	obj.CloseIdleConnections()
 	obj.client.Transport = obj.baseTransport.Clone()
+	obj.baseTransport3.fallback = obj.client.Transport
 	return nil
 }
 func (obj *Client) Close() {
 	obj.CloseIdleConnections()
+	if jar, ok := obj.jar.(*Jar); ok { //保存后台还没有保存的cookie
+		jar.Save()
+	}
 	obj.cnl()
 }
 func (obj *Client) CloseIdleConnections() {
 	obj.client.CloseIdleConnections()
+	obj.client2.CloseIdleConnections()
+	obj.client3.CloseIdleConnections()
 }
 func (obj *Client) Cookies(href string, cookies ...*http.Cookie) []*http.Cookie {
 	if obj.client.Jar == nil {
@@ -168,26 +249,70 @@ func (obj *Client) Cookies(href string, cookies ...*http.Cookie) []*http.Cookie
 	return obj.client.Jar.Cookies(u)
 }
 func (obj *Client) ClearCookies() error {
-	var jar *cookiejar.Jar
-	var err error
-	jar, err = cookiejar.New(&cookiejar.Options{
-		PublicSuffixList: publicsuffix.List,
-	})
-	if err != nil {
-		return err
+	if obj.jar == nil {
+		return nil
+	}
+	return obj.jar.Clear()
+}
+
+// 导出所有cookie,可以通过cdp.NewCookies 转换后设置到浏览器
+func (obj *Client) ExportCookies() []*http.Cookie {
+	if obj.jar == nil {
+		return nil
+	}
+	return obj.jar.AllCookies()
+}
+
+// 导入cookie,Domain 必填,以"."开头的可用于子域名,可以通过cdp.HttpCookies 转换浏览器的cookie
+func (obj *Client) ImportCookies(cookies ...*http.Cookie) error {
+	if obj.jar == nil {
+		return nil
+	}
+	if jar, ok := obj.jar.(*Jar); ok {
+		return jar.AddCookies(cookies...)
+	}
+	for _, cookie := range cookies {
+		if cookie.Domain == "" {
+			continue
+		}
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		c := *cookie
+		if !strings.HasPrefix(c.Domain, ".") {
+			c.Domain = ""
+		}
+		obj.jar.SetCookies(&url.URL{Scheme: scheme, Host: strings.TrimPrefix(cookie.Domain, "."), Path: "/"}, []*http.Cookie{&c})
 	}
-	obj.client.Jar = jar
-	obj.client.Jar = jar
 	return nil
 }
 func (obj *Client) getClient(request_option RequestOption) *http.Client {
+	var cli *http.Client
 	if request_option.DisAlive || request_option.DisCookie {
-		temp_client := obj.clone(request_option)
-		return temp_client
+		cli = obj.clone(request_option)
+	} else if request_option.Http3 {
+		cli = obj.client3
+	} else if request_option.Http2 {
+		cli = obj.client2
 	} else {
-		if request_option.Http2 {
-			return obj.client2
+		cli = obj.client
+	}
+	transport := cli.Transport
+	if obj.harReplay != nil { //从har 中返回响应
+		transport = obj.harReplay
+	} else if obj.cache != nil && !request_option.DisCache { //响应缓存
+		transport = &cacheTransport{transport: transport, option: *obj.cache}
+	}
+	if obj.har != nil { //记录har
+		transport = &harTransport{transport: transport, recorder: obj.har}
+	}
+	if transport != cli.Transport {
+		return &http.Client{
+			Transport:     transport,
+			Jar:           cli.Jar,
+			CheckRedirect: cli.CheckRedirect,
 		}
-		return obj.client
 	}
+	return cli
 }
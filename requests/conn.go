@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"gitee.com/baixudong/gospider/tools"
@@ -43,3 +45,91 @@ func (obj *httpConn) SetReadDeadline(t time.Time) error {
 func (obj *httpConn) SetWriteDeadline(t time.Time) error {
 	return obj.rawConn.SetWriteDeadline(t)
 }
+
+// 按请求的设置调整http1.1 请求头的顺序与大小写,请求头不会经过http.Header 传递
+type orderConn struct {
+	net.Conn
+	lock  sync.Mutex
+	order *reqCtxData //下一个写入的请求的设置,获取连接时设置
+	head  []byte      //还没有写完的请求头
+}
+
+// 获取连接后,写入请求前调用
+func (obj *orderConn) setOrder(reqData *reqCtxData) {
+	obj.lock.Lock()
+	obj.order = reqData
+	obj.head = nil
+	obj.lock.Unlock()
+}
+func (obj *orderConn) Write(b []byte) (n int, err error) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if obj.order == nil { //请求头已经写完
+		return obj.Conn.Write(b)
+	}
+	obj.head = append(obj.head, b...)
+	i := bytes.Index(obj.head, []byte("\r\n\r\n"))
+	if i == -1 { //请求头还没写完,先缓存
+		return len(b), nil
+	}
+	data := append(orderHead(obj.head[:i], obj.order.orderHeaders, obj.order.caseHeaders), obj.head[i:]...)
+	obj.head = nil
+	obj.order = nil
+	if _, err = obj.Conn.Write(data); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 重排请求头,head 为不包含结尾空行的请求头
+func orderHead(head []byte, orderHeaders []string, caseNames []string) []byte {
+	lines := bytes.Split(head, []byte("\r\n"))
+	caseHeaders := make(map[string]string, len(caseNames))
+	for _, key := range caseNames {
+		caseHeaders[strings.ToLower(key)] = key
+	}
+	headLines := make([][]byte, 0, len(lines))
+	names := make([]string, 0, len(lines))
+	for _, line := range lines[1:] {
+		name, _, _ := strings.Cut(tools.BytesToString(line), ":")
+		headLines = append(headLines, line)
+		names = append(names, strings.ToLower(name))
+	}
+	result := make([][]byte, 0, len(lines))
+	result = append(result, lines[0])
+	used := make([]bool, len(headLines))
+	addLine := func(i int) {
+		used[i] = true
+		line := headLines[i]
+		if key, ok := caseHeaders[names[i]]; ok {
+			line = append([]byte(key), line[len(names[i]):]...)
+		}
+		result = append(result, line)
+	}
+	addName := func(name string) {
+		for i := range headLines {
+			if !used[i] && names[i] == name {
+				addLine(i)
+			}
+		}
+	}
+	hostOrder := false
+	for _, key := range orderHeaders {
+		if strings.ToLower(key) == "host" {
+			hostOrder = true
+			break
+		}
+	}
+	if !hostOrder { //没有指定host 的顺序时,host 放在最前面
+		addName("host")
+	}
+	for _, key := range orderHeaders {
+		addName(strings.ToLower(key))
+	}
+	for i := range headLines {
+		if !used[i] {
+			addLine(i)
+		}
+	}
+	return bytes.Join(result, []byte("\r\n"))
+}
@@ -0,0 +1,117 @@
+package requests
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"reflect"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+var msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+
+func init() {
+	msgpackHandle.RawToString = true
+	msgpackHandle.MapType = reflect.TypeOf(map[string]any(nil))
+}
+
+// 根据Content-Type 判断响应体的格式:json,xml,msgpack,protobuf,未知时返回空
+func (obj *Response) ContentFormat() string {
+	mediaType, _, err := mime.ParseMediaType(obj.ContentType())
+	if err != nil {
+		return ""
+	}
+	switch {
+	case mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return "xml"
+	case strings.HasSuffix(mediaType, "msgpack"):
+		return "msgpack"
+	case strings.HasSuffix(mediaType, "protobuf") || strings.HasSuffix(mediaType, "+proto"):
+		return "protobuf"
+	}
+	return ""
+}
+
+// 根据Content-Type 解析响应体,未知格式按json 解析,protobuf 时val 必须是proto.Message
+func (obj *Response) Unmarshal(val any) error {
+	switch obj.ContentFormat() {
+	case "xml":
+		return obj.UnmarshalXml(val)
+	case "msgpack":
+		return obj.UnmarshalMsgpack(val)
+	case "protobuf":
+		msg, ok := val.(proto.Message)
+		if !ok {
+			return errors.New("protobuf 响应需要proto.Message")
+		}
+		return obj.UnmarshalProto(msg)
+	default:
+		return obj.UnmarshalJson(val)
+	}
+}
+func (obj *Response) UnmarshalJson(val any) error {
+	return json.Unmarshal(obj.content, val)
+}
+func (obj *Response) UnmarshalXml(val any) error {
+	return xml.Unmarshal(obj.content, val)
+}
+func (obj *Response) UnmarshalMsgpack(val any) error {
+	return codec.NewDecoderBytes(obj.content, msgpackHandle).Decode(val)
+}
+func (obj *Response) UnmarshalProto(val proto.Message) error {
+	return proto.Unmarshal(obj.content, val)
+}
+
+// 验证json 响应体
+func (obj *Response) ValidateSchema(schema *JsonSchema) error {
+	return schema.Validate(obj.content)
+}
+
+// 解析json 响应体为T,设置schema 时先验证
+func DecodeJSON[T any](resp *Response, schemas ...*JsonSchema) (T, error) {
+	var val T
+	for _, schema := range schemas {
+		if err := resp.ValidateSchema(schema); err != nil {
+			return val, err
+		}
+	}
+	err := resp.UnmarshalJson(&val)
+	return val, err
+}
+
+// 解析xml 响应体为T
+func DecodeXML[T any](resp *Response) (T, error) {
+	var val T
+	err := resp.UnmarshalXml(&val)
+	return val, err
+}
+
+// 解析msgpack 响应体为T
+func DecodeMsgpack[T any](resp *Response) (T, error) {
+	var val T
+	err := resp.UnmarshalMsgpack(&val)
+	return val, err
+}
+
+// 解析protobuf 响应体,T 为消息的结构体类型,如:DecodeProto[pb.User](resp)
+func DecodeProto[T any, P interface {
+	*T
+	proto.Message
+}](resp *Response) (P, error) {
+	val := P(new(T))
+	err := resp.UnmarshalProto(val)
+	return val, err
+}
+
+// 根据Content-Type 解析响应体为T,未知格式按json 解析
+func DecodeBody[T any](resp *Response) (T, error) {
+	var val T
+	err := resp.Unmarshal(&val)
+	return val, err
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
-	"fmt"
 	"net"
 	"net/http"
 	"net/url"
@@ -25,10 +24,13 @@ type dialClient struct {
 	dialer     *net.Dialer
 	dnsIpData  sync.Map
 	dnsTimeout int64
+	resolver   *resolver
+	localAddrs *LocalAddrPool
+	tls        *tlsVerifier
 }
 type msgClient struct {
 	time int64
-	addr string
+	ips  []net.IP
 }
 
 func newDail(ctx context.Context, session_option ClientOption) (*dialClient, error) {
@@ -39,12 +41,22 @@ func newDail(ctx context.Context, session_option ClientOption) (*dialClient, err
 		dnsTimeout: session_option.DnsCacheTime,
 		getProxy:   session_option.GetProxy,
 	}
+	if dialCli.resolver, err = newResolver(session_option.Resolver); err != nil {
+		return dialCli, err
+	}
+	if dialCli.tls, err = newTlsVerifier(session_option.Tls); err != nil {
+		return dialCli, err
+	}
 	if session_option.Proxy != "" {
 		if dialCli.proxy, err = verifyProxy(session_option.Proxy); err != nil {
 			return dialCli, err
 		}
 	}
-	if session_option.LocalAddr != "" {
+	if session_option.LocalAddrs != nil {
+		if dialCli.localAddrs, err = NewLocalAddrPool(*session_option.LocalAddrs); err != nil {
+			return dialCli, err
+		}
+	} else if session_option.LocalAddr != "" {
 		if !strings.Contains(session_option.LocalAddr, ":") {
 			session_option.LocalAddr += ":0"
 		}
@@ -57,33 +69,166 @@ func newDail(ctx context.Context, session_option ClientOption) (*dialClient, err
 	}
 	return dialCli, err
 }
-func (obj *dialClient) setIpData(addr string, msgData msgClient) {
-	obj.dnsIpData.Store(addr, msgData)
+func (obj *dialClient) setIpData(host string, msgData msgClient) {
+	obj.dnsIpData.Store(host, msgData)
 }
-func (obj *dialClient) addrToIp(addr string) string {
-	msgdataAny, ok := obj.dnsIpData.Load(addr)
+
+// 解析host,结果缓存DnsCacheTime 秒
+func (obj *dialClient) lookupIp(ctx context.Context, host string) ([]net.IP, error) {
+	msgdataAny, ok := obj.dnsIpData.Load(host)
 	if ok {
 		msgdata := msgdataAny.(msgClient)
 		if time.Now().Unix()-msgdata.time < obj.dnsTimeout {
-			return msgdata.addr
+			return msgdata.ips, nil
 		}
 	}
+	ips, err := obj.resolver.lookupIp(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	obj.setIpData(host, msgClient{
+		time: time.Now().Unix(),
+		ips:  ips,
+	})
+	return ips, nil
+}
+func (obj *dialClient) addrToIp(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, nil
+	}
+	ips, err := obj.lookupIp(ctx, host)
+	if err != nil {
+		return addr, err
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// 直连,同时有ipv4 与ipv6 地址时使用Happy Eyeballs
+func (obj *dialClient) dialAddr(ctx context.Context, network string, addr string) (net.Conn, error) {
 	host, port, err := net.SplitHostPort(addr)
-	if err == nil {
-		names, err := net.LookupIP(host)
-		if err == nil && len(names) != 0 {
-			addr = fmt.Sprintf("[%s]:%s", names[0].String(), port)
-			obj.setIpData(addr, msgClient{
-				time: time.Now().Unix(),
-				addr: addr,
-			})
+	if err != nil {
+		return obj.dialer.DialContext(ctx, network, addr)
+	}
+	ips, err := obj.lookupIp(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if obj.localAddrs != nil { //只连接有出口ip 的ip 版本
+		localIps := []net.IP{}
+		for _, ip := range ips {
+			if obj.localAddrs.Has(ip) {
+				localIps = append(localIps, ip)
+			}
+		}
+		if len(localIps) == 0 {
+			return nil, errors.New("没有与出口ip 版本相同的地址:" + host)
+		}
+		ips = localIps
+	}
+	var primaries, fallbacks []net.IP
+	for _, ip := range ips {
+		if ipVersion(ip) == ipVersion(ips[0]) {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(fallbacks) == 0 || obj.resolver.fallbackDelay < 0 {
+		return obj.dialSerial(ctx, network, host, ips, port)
+	}
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	raceCtx, raceCnl := context.WithCancel(ctx)
+	defer raceCnl()
+	results := make(chan dialResult)
+	race := func(ips []net.IP, primary bool) {
+		conn, err := obj.dialSerial(raceCtx, network, host, ips, port)
+		results <- dialResult{conn: conn, err: err, primary: primary}
+	}
+	go race(primaries, true)
+	running := 1
+	fallbackTimer := time.NewTimer(obj.resolver.fallbackDelay)
+	defer fallbackTimer.Stop()
+	fallbackChan := fallbackTimer.C
+	var firstErr error
+	for {
+		select {
+		case <-fallbackChan:
+			fallbackChan = nil
+			running++
+			go race(fallbacks, false)
+		case result := <-results:
+			running--
+			if result.err == nil {
+				if running > 0 { //关闭较慢的连接
+					go func() {
+						if result := <-results; result.conn != nil {
+							result.conn.Close()
+						}
+					}()
+				}
+				return result.conn, nil
+			}
+			if firstErr == nil || result.primary {
+				firstErr = result.err
+			}
+			if fallbackChan != nil { //主地址失败,立即连接备用地址
+				fallbackChan = nil
+				running++
+				go race(fallbacks, false)
+			} else if running == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// 出口ip 按请求的目标host 分配,使用代理时host 是代理的地址
+func localAddrHost(ctx context.Context, host string) string {
+	if reqData, ok := ctx.Value(keyPrincipalID).(*reqCtxData); ok && reqData.url != nil {
+		return reqData.url.Hostname()
+	}
+	return host
+}
+
+// 按顺序连接ip,直到成功
+func (obj *dialClient) dialSerial(ctx context.Context, network string, host string, ips []net.IP, port string) (net.Conn, error) {
+	var err error
+	for _, ip := range ips {
+		dialer := obj.dialer
+		if obj.localAddrs != nil {
+			if localIp := obj.localAddrs.Get(localAddrHost(ctx, host), ip); localIp != nil {
+				localDialer := *obj.dialer
+				localDialer.LocalAddr = &net.TCPAddr{IP: localIp}
+				dialer = &localDialer
+			}
+		}
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
-	return addr
+	return nil, err
 }
 
+// 使用dialClient 连接socks5 代理
+type forwardDialer struct {
+	ctx     context.Context
+	dialCli *dialClient
+}
+
+func (obj *forwardDialer) Dial(network string, addr string) (net.Conn, error) {
+	return obj.dialCli.dialAddr(obj.ctx, network, addr)
+}
 func (obj *dialClient) getSocksProxyConn(ctx context.Context, proxyData *url.URL, addr string) (net.Conn, error) {
-	dial, err := proxy.FromURL(proxyData, obj.dialer)
+	dial, err := proxy.FromURL(proxyData, &forwardDialer{ctx: ctx, dialCli: obj})
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +247,7 @@ func (obj *dialClient) getHttpProxyConn(ctx context.Context, proxyData *url.URL)
 	return rawConn, err
 }
 func (obj *dialClient) getHttpConn(ctx context.Context, proxyData *url.URL) (net.Conn, error) {
-	return obj.dialer.DialContext(ctx, "tcp", net.JoinHostPort(proxyData.Hostname(), proxyData.Port()))
+	return obj.dialAddr(ctx, "tcp", net.JoinHostPort(proxyData.Hostname(), proxyData.Port()))
 }
 func Http2httpsConn(ctx context.Context, proxyData *url.URL, addr string, host string, conn net.Conn) error {
 	var err error
@@ -145,16 +290,32 @@ func Http2httpsConn(ctx context.Context, proxyData *url.URL, addr string, host s
 	}
 	return nil
 }
+
+// 获取请求使用的代理
+func (obj *dialClient) getProxyUrl(ctx context.Context, reqData *reqCtxData) (*url.URL, error) {
+	if reqData.disProxy {
+		return nil, nil
+	} else if reqData.proxy != nil {
+		return reqData.proxy, nil
+	} else if obj.getProxy != nil {
+		proxyUrl, err := obj.getProxy(ctx, reqData.url)
+		if err != nil {
+			return nil, err
+		}
+		return verifyProxy(proxyUrl)
+	}
+	return obj.proxy, nil
+}
 func (obj *dialClient) dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
 	reqData := ctx.Value(keyPrincipalID).(*reqCtxData)
 	if reqData.url == nil {
 		return nil, tools.WrapError(errFatal, "not found reqData.url")
 	}
 	if reqData.disProxy {
-		return obj.dialer.DialContext(ctx, network, obj.addrToIp(addr))
+		return obj.dialAddr(ctx, network, addr)
 	} else if reqData.proxy != nil {
-		if !reqData.ja3 && !reqData.h2 { //ja3 必须https 才能设置，http2 的transport 没有proxy 方法
-			rawConn, err := obj.dialer.DialContext(ctx, network, obj.addrToIp(addr))
+		if !reqData.ja3 && !reqData.h2 && reqData.url.Scheme == "http" { //ja3 必须https 才能设置，http2 的transport 没有proxy 方法,https 的代理在下面处理
+			rawConn, err := obj.dialAddr(ctx, network, addr)
 			if err != nil {
 				return rawConn, err
 			}
@@ -182,17 +343,19 @@ func (obj *dialClient) dialContext(ctx context.Context, network string, addr str
 	if reqData.proxy != nil {
 		switch reqData.proxy.Scheme {
 		case "socks5":
-			return obj.getSocksProxyConn(ctx, reqData.proxy, obj.addrToIp(addr))
+			ipAddr, _ := obj.addrToIp(ctx, addr) //解析失败时使用原地址,由代理解析域名
+			return obj.getSocksProxyConn(ctx, reqData.proxy, ipAddr)
 		case "http":
 			switch reqData.url.Scheme {
 			case "http":
 				return obj.getHttpProxyConn(ctx, reqData.proxy)
 			case "https":
+				ipAddr, _ := obj.addrToIp(ctx, addr) //解析失败时使用原地址,由代理解析域名
 				conn, err := obj.getHttpConn(ctx, reqData.proxy)
 				if err != nil {
 					return conn, err
 				}
-				if err = Http2httpsConn(ctx, reqData.proxy, obj.addrToIp(addr), addr, conn); err != nil {
+				if err = Http2httpsConn(ctx, reqData.proxy, ipAddr, addr, conn); err != nil {
 					conn.Close()
 				}
 				return conn, err
@@ -201,7 +364,7 @@ func (obj *dialClient) dialContext(ctx context.Context, network string, addr str
 			}
 		}
 	}
-	return obj.dialer.DialContext(ctx, network, obj.addrToIp(addr))
+	return obj.dialAddr(ctx, network, addr)
 }
 
 func (obj *dialClient) dialTlsContext(ctx context.Context, network string, addr string) (net.Conn, error) {
@@ -210,14 +373,13 @@ func (obj *dialClient) dialTlsContext(ctx context.Context, network string, addr
 		return nil, err
 	}
 	reqData := ctx.Value(keyPrincipalID).(*reqCtxData)
-	colonPos := strings.LastIndex(addr, ":")
-	if colonPos == -1 {
-		colonPos = len(addr)
+	serverName, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		serverName = addr
 	}
-	serverName := addr[:colonPos]
 	if reqData.ja3 {
-		tlsConn := utls.UClient(conn, &utls.Config{InsecureSkipVerify: true, ServerName: serverName}, utls.HelloCustom)
-		spec, err := utls.UTLSIdToSpec(utls.HelloChrome_Auto)
+		tlsConn := utls.UClient(conn, obj.tls.utlsConfig(serverName), utls.HelloCustom)
+		spec, err := reqData.ja3Spec.Spec()
 		if err != nil {
 			conn.Close()
 			return nil, err
@@ -245,8 +407,9 @@ func (obj *dialClient) dialTlsContext(ctx context.Context, network string, addr
 		}
 		return tlsConn, err
 	}
-	return tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: serverName}), err
-}
-func (obj *dialClient) dialTlsContext2(ctx context.Context, network string, addr string, cfg *tls.Config) (net.Conn, error) {
-	return obj.dialTlsContext(ctx, network, addr)
+	tlsConfig := obj.tls.tlsConfig(serverName)
+	if reqData.h2 {
+		tlsConfig.NextProtos = []string{"h2"}
+	}
+	return tls.Client(conn, tlsConfig), err
 }
@@ -0,0 +1,440 @@
+package requests
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/bar"
+)
+
+// 下载参数,RequestOption.Bar 开启进度条
+type DownloadOption struct {
+	RequestOption
+	Threads   int    //分段下载的并发数,服务器支持Range 时生效,default:1
+	DisResume bool   //关闭断点续传,每次重新下载
+	Md5       string //文件的md5,下载完成后校验
+	Sha1      string //文件的sha1,下载完成后校验
+	Sha256    string //文件的sha256,下载完成后校验
+}
+
+// 分段下载的进度,End 包含在内
+type downloadSegment struct {
+	Start int64
+	End   int64
+	Done  int64
+}
+
+// 断点续传的进度文件
+type downloadState struct {
+	lock      sync.Mutex
+	Size      int64
+	Validator string //ETag 或Last-Modified,用于If-Range 判断文件是否变化
+	Segments  []*downloadSegment
+}
+
+func newDownloadState(size int64, threads int, validator string) *downloadState {
+	state := &downloadState{Size: size, Validator: validator}
+	if int64(threads) > size {
+		threads = int(size)
+	}
+	segmentSize := size / int64(threads)
+	for i := 0; i < threads; i++ {
+		segment := &downloadSegment{Start: int64(i) * segmentSize, End: int64(i+1)*segmentSize - 1}
+		if i == threads-1 { //最后一段包含剩余的部分
+			segment.End = size - 1
+		}
+		state.Segments = append(state.Segments, segment)
+	}
+	return state
+}
+func loadDownloadState(path string) (*downloadState, error) {
+	con, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	state := &downloadState{}
+	if err = json.Unmarshal(con, state); err != nil || state.Size <= 0 || len(state.Segments) == 0 { //进度文件损坏时重新下载
+		return nil, nil
+	}
+	return state, nil
+}
+func (obj *downloadState) save(path string) error {
+	obj.lock.Lock()
+	con, err := json.Marshal(obj)
+	obj.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, con, 0644)
+}
+func (obj *downloadState) done() int64 {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	var done int64
+	for _, segment := range obj.Segments {
+		done += segment.Done
+	}
+	return done
+}
+
+// 从Content-Range 中解析文件大小
+func parseContentRange(value string) (start int64, size int64, err error) {
+	value = strings.TrimSpace(strings.TrimPrefix(value, "bytes"))
+	rangeStr, sizeStr, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0, errors.New("Content-Range 格式错误:" + value)
+	}
+	if sizeStr == "*" {
+		size = -1
+	} else if size, err = strconv.ParseInt(sizeStr, 10, 64); err != nil {
+		return
+	}
+	if rangeStr == "*" {
+		return
+	}
+	startStr, _, _ := strings.Cut(rangeStr, "-")
+	start, err = strconv.ParseInt(startStr, 10, 64)
+	return
+}
+
+// 文件校验器
+type downloadHash struct {
+	name  string
+	hash  hash.Hash
+	value string
+}
+
+func (obj *DownloadOption) hashs() []*downloadHash {
+	hashs := []*downloadHash{}
+	if obj.Md5 != "" {
+		hashs = append(hashs, &downloadHash{name: "md5", hash: md5.New(), value: obj.Md5})
+	}
+	if obj.Sha1 != "" {
+		hashs = append(hashs, &downloadHash{name: "sha1", hash: sha1.New(), value: obj.Sha1})
+	}
+	if obj.Sha256 != "" {
+		hashs = append(hashs, &downloadHash{name: "sha256", hash: sha256.New(), value: obj.Sha256})
+	}
+	return hashs
+}
+
+// 校验文件
+func verifyFile(path string, hashs []*downloadHash) error {
+	if len(hashs) == 0 {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writers := make([]io.Writer, len(hashs))
+	for i, hs := range hashs {
+		writers[i] = hs.hash
+	}
+	if _, err = io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return err
+	}
+	for _, hs := range hashs {
+		if sum := hex.EncodeToString(hs.hash.Sum(nil)); !strings.EqualFold(sum, hs.value) {
+			return fmt.Errorf("%s 校验失败,期望:%s,实际:%s", hs.name, hs.value, sum)
+		}
+	}
+	return nil
+}
+
+// 写入文件指定位置
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+	write  func(int64) //每次写入后的回调
+}
+
+func (obj *offsetWriter) Write(con []byte) (int, error) {
+	n, err := obj.file.WriteAt(con, obj.offset)
+	obj.offset += int64(n)
+	if n > 0 {
+		obj.write(int64(n))
+	}
+	return n, err
+}
+
+// 请求指定范围,end 小于0 时请求到文件末尾
+func (obj *Client) rangeRequest(ctx context.Context, href string, option RequestOption, start, end int64, validator string) (*Response, error) {
+	headers := option.Headers.(http.Header).Clone()
+	if end < 0 {
+		headers.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	} else {
+		headers.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	if validator != "" {
+		headers.Set("If-Range", validator)
+	}
+	headers.Set("Accept-Encoding", "identity") //压缩后无法按字节续传
+	option.Headers = headers
+	option.DisRead = true
+	option.DisUnZip = true
+	option.DisCache = true
+	return obj.Request(ctx, "get", href, option)
+}
+
+// 下载文件,支持断点续传,分段下载,校验
+//
+// 下载中的文件保存为path.download,进度保存为path.download.json,下载完成后重命名为path
+func (obj *Client) Download(preCtx context.Context, href string, path string, options ...DownloadOption) error {
+	if obj == nil {
+		return errors.New("初始化client失败")
+	}
+	if preCtx == nil {
+		preCtx = obj.ctx
+	}
+	var option DownloadOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.Threads <= 0 {
+		option.Threads = 1
+	}
+	obj.newRequestOption(&option.RequestOption)
+	if err := option.newHeaders(); err != nil {
+		return err
+	}
+	hashs := option.hashs()
+	tempPath := path + ".download"
+	statePath := tempPath + ".json"
+	if option.DisResume {
+		os.Remove(tempPath)
+		os.Remove(statePath)
+	}
+	state, err := loadDownloadState(statePath)
+	if err != nil {
+		return err
+	}
+	var firstResp *Response
+	if state == nil {
+		if firstResp, err = obj.rangeRequest(preCtx, href, option.RequestOption, 0, -1, ""); err != nil {
+			return err
+		}
+		switch firstResp.StatusCode() {
+		case 206:
+			_, size, err := parseContentRange(firstResp.Headers().Get("Content-Range"))
+			if err != nil {
+				firstResp.closeNoRead()
+				return err
+			}
+			if size <= 0 {
+				break
+			}
+			validator := firstResp.Headers().Get("ETag")
+			if validator == "" || strings.HasPrefix(validator, "W/") { //弱ETag 不能用于If-Range
+				validator = firstResp.Headers().Get("Last-Modified")
+			}
+			state = newDownloadState(size, option.Threads, validator)
+			if len(state.Segments) > 1 {
+				firstResp.closeNoRead()
+				firstResp = nil
+			}
+		case 200:
+		default:
+			firstResp.closeNoRead()
+			return fmt.Errorf("下载失败,状态码:%d", firstResp.StatusCode())
+		}
+		if state == nil { //服务器不支持Range,直接下载
+			return obj.downloadAll(firstResp, path, tempPath, option.Bar, hashs)
+		}
+		os.Remove(tempPath)
+	}
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		if firstResp != nil {
+			firstResp.closeNoRead()
+		}
+		return err
+	}
+	if err = file.Truncate(state.Size); err != nil {
+		file.Close()
+		if firstResp != nil {
+			firstResp.closeNoRead()
+		}
+		return err
+	}
+	err = obj.downloadSegments(preCtx, href, option, file, state, statePath, firstResp)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	os.Remove(statePath)
+	if err = verifyFile(tempPath, hashs); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// 不支持断点续传时,下载整个文件,边下载边校验
+func (obj *Client) downloadAll(resp *Response, path string, tempPath string, isBar bool, hashs []*downloadHash) error {
+	file, err := os.Create(tempPath)
+	if err != nil {
+		resp.closeNoRead()
+		return err
+	}
+	writers := []io.Writer{file}
+	for _, hs := range hashs {
+		writers = append(writers, hs.hash)
+	}
+	var body io.Reader = resp.response.Body
+	if isBar && resp.ContentLength() > 0 {
+		body = &barBody{body: body, bar: bar.NewClient(resp.ContentLength())}
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), body)
+	resp.Close()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	for _, hs := range hashs {
+		if sum := hex.EncodeToString(hs.hash.Sum(nil)); !strings.EqualFold(sum, hs.value) {
+			os.Remove(tempPath)
+			return fmt.Errorf("%s 校验失败,期望:%s,实际:%s", hs.name, hs.value, sum)
+		}
+	}
+	return os.Rename(tempPath, path)
+}
+
+// 并发下载所有分段,每秒保存一次进度,失败时保存进度后返回
+func (obj *Client) downloadSegments(preCtx context.Context, href string, option DownloadOption, file *os.File, state *downloadState, statePath string, firstResp *Response) error {
+	ctx, cnl := context.WithCancel(preCtx)
+	defer cnl()
+	var barCli *bar.Client
+	if option.Bar {
+		barCli = bar.NewClient(state.Size, bar.ClientOption{Cur: state.done()})
+	}
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var downErr error
+	for _, segment := range state.Segments {
+		state.lock.Lock()
+		start := segment.Start + segment.Done
+		state.lock.Unlock()
+		if start > segment.End {
+			continue
+		}
+		var resp *Response
+		if firstResp != nil { //只有一段时复用第一次请求
+			resp, firstResp = firstResp, nil
+		}
+		wg.Add(1)
+		go func(segment *downloadSegment, start int64, resp *Response) {
+			defer wg.Done()
+			if err := obj.downloadSegment(ctx, href, option.RequestOption, file, state, segment, start, resp, barCli); err != nil {
+				errOnce.Do(func() {
+					downErr = err
+					cnl()
+				})
+			}
+		}(segment, start, resp)
+	}
+	if firstResp != nil {
+		firstResp.closeNoRead()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			if downErr != nil {
+				if errors.Is(downErr, errDownloadChanged) {
+					os.Remove(statePath)
+					os.Remove(file.Name())
+				} else {
+					state.save(statePath)
+				}
+				return downErr
+			}
+			return nil
+		case <-ticker.C:
+			state.save(statePath)
+		}
+	}
+}
+
+var errDownloadChanged = errors.New("服务器上的文件已变化,请重新下载")
+
+// 下载一个分段,resp 不为nil 时直接读取
+func (obj *Client) downloadSegment(ctx context.Context, href string, option RequestOption, file *os.File, state *downloadState, segment *downloadSegment, start int64, resp *Response, barCli *bar.Client) (err error) {
+	if resp == nil {
+		if resp, err = obj.rangeRequest(ctx, href, option, start, segment.End, state.Validator); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if err != nil {
+			resp.closeNoRead()
+		} else {
+			resp.Close()
+		}
+	}()
+	switch resp.StatusCode() {
+	case 206:
+		var respStart int64
+		if respStart, _, err = parseContentRange(resp.Headers().Get("Content-Range")); err != nil {
+			return err
+		}
+		if respStart != start {
+			return fmt.Errorf("Content-Range 起始位置错误,期望:%d,实际:%d", start, respStart)
+		}
+	case 200: //If-Range 不匹配时返回整个文件
+		return errDownloadChanged
+	default:
+		return fmt.Errorf("下载失败,状态码:%d", resp.StatusCode())
+	}
+	writer := &offsetWriter{
+		file:   file,
+		offset: start,
+		write: func(n int64) {
+			state.lock.Lock()
+			segment.Done += n
+			state.lock.Unlock()
+			if barCli != nil {
+				barCli.Print(n)
+			}
+		},
+	}
+	_, err = io.Copy(writer, io.LimitReader(resp.response.Body, segment.End-start+1))
+	if err != nil {
+		return err
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if segment.Start+segment.Done <= segment.End {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
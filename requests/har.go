@@ -0,0 +1,546 @@
+package requests
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gitee.com/baixudong/gospider/tools"
+	utls "github.com/refraction-networking/utls"
+)
+
+// har 1.2 格式,以"_"开头的字段为自定义字段
+type Har struct {
+	Log HarLog `json:"log"`
+}
+type HarLog struct {
+	Version string     `json:"version"`
+	Creator HarCreator `json:"creator"`
+	Entries []HarEntry `json:"entries"`
+}
+type HarCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+type HarEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"` //总耗时,毫秒
+	Request         HarRequest  `json:"request"`
+	Response        HarResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HarTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Proxy           string      `json:"_proxy,omitempty"` //使用的代理,不包含密码
+	Ja3             string      `json:"_ja3,omitempty"`   //tls 指纹
+	Error           string      `json:"_error,omitempty"` //请求错误
+}
+type HarRequest struct {
+	Method      string         `json:"method"`
+	Url         string         `json:"url"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []HarCookie    `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	QueryString []HarNameValue `json:"queryString"`
+	PostData    *HarPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+type HarResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []HarCookie    `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	Content     HarContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+type HarCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HttpOnly bool   `json:"httpOnly"`
+	Secure   bool   `json:"secure"`
+}
+type HarNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+type HarPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+type HarContent struct {
+	Size     int64  `json:"size"` //解压后的大小
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"` //非utf8 内容使用base64
+	Comment  string `json:"comment,omitempty"`
+}
+
+// 各阶段耗时,毫秒,-1 表示没有该阶段
+type HarTimings struct {
+	Blocked float64 `json:"blocked"`
+	Dns     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	Ssl     float64 `json:"ssl"`
+}
+
+// 读取har 文件
+func LoadHar(path string) (*Har, error) {
+	con, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	har := new(Har)
+	return har, json.Unmarshal(con, har)
+}
+
+// 保存为har 文件
+func (obj *Har) Save(path string) error {
+	con, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, con, 0644)
+}
+
+// 记录请求与响应,响应体读取完成或关闭后记录
+type HarRecorder struct {
+	MaxBodySize int64 //记录的最大响应体大小,超过时不记录响应体,0 不限制
+	lock        sync.Mutex
+	entries     []HarEntry
+}
+
+func (obj *HarRecorder) add(entry HarEntry) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.entries = append(obj.entries, entry)
+}
+
+// 已记录的请求
+func (obj *HarRecorder) Entries() []HarEntry {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	entries := make([]HarEntry, len(obj.entries))
+	copy(entries, obj.entries)
+	return entries
+}
+
+// 清空记录
+func (obj *HarRecorder) Clear() {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.entries = nil
+}
+func (obj *HarRecorder) Har() *Har {
+	return &Har{Log: HarLog{
+		Version: "1.2",
+		Creator: HarCreator{Name: "gospider"},
+		Entries: obj.Entries(),
+	}}
+}
+
+// 保存为har 文件
+func (obj *HarRecorder) Save(path string) error {
+	return obj.Har().Save(path)
+}
+
+func harTime(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return -1
+	}
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
+func harHeaders(headers http.Header) []HarNameValue {
+	values := []HarNameValue{}
+	for key, vals := range headers {
+		if strings.HasPrefix(key, "!") { //内部标记
+			continue
+		}
+		for _, val := range vals {
+			values = append(values, HarNameValue{Name: key, Value: val})
+		}
+	}
+	return values
+}
+func harCookies(cookies []*http.Cookie) []HarCookie {
+	values := []HarCookie{}
+	for _, cookie := range cookies {
+		value := HarCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HttpOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		}
+		if !cookie.Expires.IsZero() {
+			value.Expires = cookie.Expires.Format(time.RFC3339)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// tls 指纹,预设指纹返回名称,自定义指纹返回ja3 字符串
+func harJa3(spec Ja3Spec) string {
+	if !spec.IsSet() { //与Spec 一致,default:chrome
+		return utls.HelloChrome_Auto.Str()
+	}
+	if spec.id.Client != "" {
+		return spec.id.Str()
+	}
+	uint16s := func(vals []uint16) string {
+		strs := make([]string, len(vals))
+		for i, val := range vals {
+			strs[i] = strconv.Itoa(int(val))
+		}
+		return strings.Join(strs, "-")
+	}
+	points := make([]uint16, len(spec.PointFormats))
+	for i, point := range spec.PointFormats {
+		points[i] = uint16(point)
+	}
+	return fmt.Sprintf("%d,%s,%s,%s,%s", spec.TLSVersMax, uint16s(spec.CipherSuites), uint16s(spec.Extensions), uint16s(spec.Curves), uint16s(points))
+}
+
+// 请求各阶段的时间
+type harTrace struct {
+	lock      sync.Mutex
+	start     time.Time
+	dnsStart  time.Time
+	dnsDone   time.Time
+	connStart time.Time
+	connDone  time.Time
+	tlsStart  time.Time
+	tlsDone   time.Time
+	gotConn   time.Time
+	wrote     time.Time
+	firstByte time.Time
+	headers   time.Time
+	remote    string
+}
+
+func (obj *harTrace) set(t *time.Time) {
+	obj.lock.Lock()
+	*t = time.Now()
+	obj.lock.Unlock()
+}
+func (obj *harTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { obj.set(&obj.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { obj.set(&obj.dnsDone) },
+		ConnectStart:      func(string, string) { obj.set(&obj.connStart) },
+		ConnectDone:       func(string, string, error) { obj.set(&obj.connDone) },
+		TLSHandshakeStart: func() { obj.set(&obj.tlsStart) },
+		TLSHandshakeDone:  func(_ tls.ConnectionState, _ error) { obj.set(&obj.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			obj.lock.Lock()
+			obj.gotConn = time.Now()
+			if info.Conn != nil {
+				obj.remote = info.Conn.RemoteAddr().String()
+			}
+			obj.lock.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { obj.set(&obj.wrote) },
+		GotFirstResponseByte: func() { obj.set(&obj.firstByte) },
+	}
+}
+
+// 转换为har 的耗时,没有触发的阶段为-1
+func (obj *harTrace) timings(end time.Time) HarTimings {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	timings := HarTimings{
+		Blocked: -1,
+		Dns:     harTime(obj.dnsStart, obj.dnsDone),
+		Connect: harTime(obj.connStart, obj.connDone),
+		Ssl:     harTime(obj.tlsStart, obj.tlsDone),
+	}
+	if timings.Ssl > 0 && timings.Connect >= 0 { //connect 包含ssl
+		timings.Connect += timings.Ssl
+	}
+	sendStart := obj.gotConn
+	if sendStart.IsZero() {
+		sendStart = obj.start
+	}
+	waitStart := obj.wrote
+	if waitStart.IsZero() {
+		waitStart = sendStart
+	} else {
+		timings.Send = harTime(sendStart, obj.wrote)
+	}
+	waitEnd := obj.firstByte
+	if waitEnd.IsZero() {
+		waitEnd = obj.headers
+	}
+	timings.Wait = harTime(waitStart, waitEnd)
+	timings.Receive = harTime(waitEnd, end)
+	return timings
+}
+
+// 记录请求的transport
+type harTransport struct {
+	transport http.RoundTripper
+	recorder  *HarRecorder
+}
+
+// 记录经过transport 的请求与响应,可用于其它的http.Client,请求体需要设置GetBody 才会被记录
+func NewHarTransport(transport http.RoundTripper, recorder *HarRecorder) http.RoundTripper {
+	return &harTransport{transport: transport, recorder: recorder}
+}
+
+func (obj *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &harTrace{start: time.Now()}
+	entry := HarEntry{StartedDateTime: trace.start.Format("2006-01-02T15:04:05.000Z07:00")}
+	entry.Request = HarRequest{
+		Method:      req.Method,
+		Url:         req.URL.String(),
+		HttpVersion: "HTTP/1.1",
+		Cookies:     harCookies(req.Cookies()),
+		Headers:     harHeaders(req.Header),
+		QueryString: []HarNameValue{},
+		HeadersSize: -1,
+		BodySize:    req.ContentLength,
+	}
+	for key, vals := range req.URL.Query() {
+		for _, val := range vals {
+			entry.Request.QueryString = append(entry.Request.QueryString, HarNameValue{Name: key, Value: val})
+		}
+	}
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			con, _ := io.ReadAll(body)
+			body.Close()
+			entry.Request.PostData = &HarPostData{MimeType: req.Header.Get("Content-Type"), Text: tools.BytesToString(con)}
+			entry.Request.BodySize = int64(len(con))
+		}
+	}
+	reqData, _ := req.Context().Value(keyPrincipalID).(*reqCtxData)
+	if reqData != nil {
+		if reqData.ja3 && req.URL.Scheme == "https" {
+			entry.Ja3 = harJa3(reqData.ja3Spec)
+		}
+		if reqData.h2 {
+			entry.Request.HttpVersion = "HTTP/2.0"
+		} else if reqData.h3 {
+			entry.Request.HttpVersion = "HTTP/3.0"
+		}
+	}
+	resp, err := obj.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace())))
+	trace.set(&trace.headers)
+	if reqData != nil && reqData.proxy != nil { //使用GetProxy 时在建立连接时才会设置代理
+		proxy := *reqData.proxy
+		proxy.User = nil
+		entry.Proxy = proxy.String()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Response = HarResponse{Cookies: []HarCookie{}, Headers: []HarNameValue{}, HeadersSize: -1, BodySize: -1}
+		obj.finish(entry, trace)
+		return resp, err
+	}
+	entry.Request.HttpVersion = resp.Proto
+	entry.Response = HarResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		HttpVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harHeaders(resp.Header),
+		Content:     HarContent{MimeType: resp.Header.Get("Content-Type")},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+	if resp.Body == nil {
+		obj.finish(entry, trace)
+		return resp, err
+	}
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	resp.Body = &harBody{
+		body:    resp.Body,
+		maxSize: obj.recorder.MaxBodySize,
+		callBack: func(con []byte, over bool) {
+			entry.Response.BodySize = int64(len(con))
+			if over {
+				entry.Response.BodySize = -1
+				entry.Response.Content.Size = -1
+				entry.Response.Content.Comment = "响应体超过MaxBodySize,没有记录"
+			} else {
+				obj.setContent(&entry.Response.Content, con, contentEncoding)
+			}
+			obj.finish(entry, trace)
+		},
+	}
+	return resp, err
+}
+
+// 解压后记录响应体,非utf8 内容使用base64
+func (obj *harTransport) setContent(content *HarContent, con []byte, contentEncoding string) {
+	if contentEncoding != "" {
+		if body, err := tools.ZipDecode(bytes.NewBuffer(con), contentEncoding); err == nil {
+			con = body.Bytes()
+		}
+	}
+	content.Size = int64(len(con))
+	if utf8.Valid(con) {
+		content.Text = string(con)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(con)
+		content.Encoding = "base64"
+	}
+}
+func (obj *harTransport) finish(entry HarEntry, trace *harTrace) {
+	end := time.Now()
+	entry.Time = harTime(trace.start, end)
+	entry.Timings = trace.timings(end)
+	trace.lock.Lock()
+	if host, _, err := net.SplitHostPort(trace.remote); err == nil {
+		entry.ServerIPAddress = host
+	}
+	trace.lock.Unlock()
+	obj.recorder.add(entry)
+}
+
+// 读取完成或关闭时记录响应体
+type harBody struct {
+	body     io.ReadCloser
+	buf      bytes.Buffer
+	maxSize  int64
+	over     bool
+	once     sync.Once
+	callBack func([]byte, bool)
+}
+
+func (obj *harBody) Read(p []byte) (int, error) {
+	n, err := obj.body.Read(p)
+	if !obj.over {
+		if obj.maxSize > 0 && int64(obj.buf.Len()+n) > obj.maxSize {
+			obj.over = true
+			obj.buf = bytes.Buffer{}
+		} else {
+			obj.buf.Write(p[:n])
+		}
+	}
+	if err != nil {
+		obj.once.Do(func() { obj.callBack(obj.buf.Bytes(), obj.over) })
+	}
+	return n, err
+}
+func (obj *harBody) Close() error {
+	obj.once.Do(func() { obj.callBack(obj.buf.Bytes(), obj.over) })
+	return obj.body.Close()
+}
+
+// 从har 中返回响应,不请求网络
+type harReplayTransport struct {
+	lock    sync.Mutex
+	entries map[string][]*HarEntry //key:method url
+	used    map[string]int
+}
+
+func newHarReplayTransport(har *Har) *harReplayTransport {
+	obj := &harReplayTransport{
+		entries: map[string][]*HarEntry{},
+		used:    map[string]int{},
+	}
+	for i := range har.Log.Entries {
+		entry := &har.Log.Entries[i]
+		if entry.Error != "" || entry.Response.Status == 0 {
+			continue
+		}
+		key := strings.ToUpper(entry.Request.Method) + " " + entry.Request.Url
+		obj.entries[key] = append(obj.entries[key], entry)
+	}
+	return obj
+}
+
+// 相同请求按顺序返回,请求体相同的优先
+func (obj *harReplayTransport) getEntry(req *http.Request) (*HarEntry, error) {
+	key := req.Method + " " + req.URL.String()
+	entries := obj.entries[key]
+	if len(entries) == 0 {
+		return nil, tools.WrapError(errFatal, "har 中没有找到请求:", key)
+	}
+	if req.Body != nil && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			con, _ := io.ReadAll(body)
+			body.Close()
+			sameEntries := []*HarEntry{}
+			for _, entry := range entries {
+				if entry.Request.PostData != nil && entry.Request.PostData.Text == string(con) {
+					sameEntries = append(sameEntries, entry)
+				}
+			}
+			if len(sameEntries) > 0 {
+				entries = sameEntries
+				key += " " + string(con)
+			}
+		}
+	}
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	index := obj.used[key]
+	obj.used[key]++
+	if index >= len(entries) { //超过记录次数时返回最后一个
+		index = len(entries) - 1
+	}
+	return entries[index], nil
+}
+func (obj *harReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry, err := obj.getEntry(req)
+	if err != nil {
+		return nil, err
+	}
+	var con []byte
+	if entry.Response.Content.Encoding == "base64" {
+		if con, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text); err != nil {
+			return nil, err
+		}
+	} else {
+		con = tools.StringToBytes(entry.Response.Content.Text)
+	}
+	header := http.Header{}
+	for _, kv := range entry.Response.Headers {
+		header.Add(kv.Name, kv.Value)
+	}
+	//记录的是解压后的内容
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(con)))
+	proto := entry.Response.HttpVersion
+	protoMajor, protoMinor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		proto, protoMajor, protoMinor = "HTTP/1.1", 1, 1
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, entry.Response.StatusText),
+		StatusCode:    entry.Response.Status,
+		Proto:         proto,
+		ProtoMajor:    protoMajor,
+		ProtoMinor:    protoMinor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(con)),
+		ContentLength: int64(len(con)),
+		Request:       req,
+	}, nil
+}
+func (obj *harReplayTransport) CloseIdleConnections() {}
@@ -0,0 +1,1204 @@
+package requests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+var (
+	errH2Retry       = errors.New("http2 request can be retried")
+	errH2ClosedBody  = errors.New("http2: response body closed")
+	errH2ConnClosed  = errors.New("http2: client connection closed")
+	errH2StreamReset = errors.New("http2: stream reset")
+)
+
+// 服务器的alpn 不支持http2,可以使用http1.1 重新请求
+var ErrH2NotSupport = errors.New("http2: server does not support http2")
+
+// 不能在http2 中发送的请求头
+var h2ConnHeaders = map[string]bool{
+	"host":              true,
+	"connection":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+	"keep-alive":        true,
+}
+
+// 支持自定义指纹的http2 transport
+type http2Transport struct {
+	dialContext      func(ctx context.Context, network string, addr string) (net.Conn, error)
+	dialTlsContext   func(ctx context.Context, network string, addr string) (net.Conn, error)
+	idleConnTimeout  time.Duration //空闲连接在连接池中的超时时间
+	readIdleTimeout  time.Duration //检测连接是否健康的间隔时间
+	pingTimeout      time.Duration //ping 超时时间
+	writeByteTimeout time.Duration //写超时时间
+	disAlive         bool          //请求结束后关闭连接
+
+	mu      sync.Mutex
+	conns   map[string][]*http2ClientConn
+	dialing map[string]*http2DialCall
+}
+type http2DialCall struct {
+	done chan struct{}
+	err  error
+}
+
+func newHttp2Transport(ctx context.Context, session_option ClientOption, dialCli *dialClient) *http2Transport {
+	return &http2Transport{
+		dialContext:      dialCli.dialContext,
+		dialTlsContext:   dialCli.dialTlsContext,
+		idleConnTimeout:  time.Duration(session_option.IdleConnTimeout) * time.Second,
+		readIdleTimeout:  time.Duration(session_option.IdleConnTimeout) * time.Second,
+		pingTimeout:      time.Second * time.Duration(session_option.TLSHandshakeTimeout),
+		writeByteTimeout: time.Second * time.Duration(session_option.ResponseHeaderTimeout),
+	}
+}
+
+// 复制配置,不复制连接池
+func (obj *http2Transport) clone() *http2Transport {
+	return &http2Transport{
+		dialContext:      obj.dialContext,
+		dialTlsContext:   obj.dialTlsContext,
+		idleConnTimeout:  obj.idleConnTimeout,
+		readIdleTimeout:  obj.readIdleTimeout,
+		pingTimeout:      obj.pingTimeout,
+		writeByteTimeout: obj.writeByteTimeout,
+		disAlive:         obj.disAlive,
+	}
+}
+func (obj *http2Transport) CloseIdleConnections() {
+	obj.mu.Lock()
+	conns := []*http2ClientConn{}
+	for _, ccs := range obj.conns {
+		conns = append(conns, ccs...)
+	}
+	obj.mu.Unlock()
+	for _, cc := range conns {
+		cc.closeIfIdle()
+	}
+}
+func (obj *http2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" && req.URL.Scheme != "http" {
+		return nil, errors.New("http2: unsupported scheme " + req.URL.Scheme)
+	}
+	var spec H2Ja3Spec
+	var connKey string
+	if reqData, ok := req.Context().Value(keyPrincipalID).(*reqCtxData); ok {
+		spec = reqData.h2Ja3Spec
+		if !spec.IsSet() && reqData.ja3 {
+			spec = reqData.ja3Spec.h2Spec()
+		}
+		if reqData.proxy != nil {
+			connKey = reqData.proxy.String()
+		}
+		connKey = fmt.Sprintf("%s@%s@%s@%s", connKey, reqData.ja3Spec.key(), spec.key(), strconv.FormatBool(reqData.ja3))
+	}
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		if req.URL.Scheme == "https" {
+			addr = net.JoinHostPort(req.URL.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+	connKey = req.URL.Scheme + "://" + addr + "@" + connKey
+	for tryNum := 0; ; tryNum++ {
+		cc, err := obj.getClientConn(req, connKey, addr, spec)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := cc.roundTrip(req)
+		if err != nil && errors.Is(err, errH2Retry) && tryNum < 3 {
+			if req.Body != nil && req.Body != http.NoBody {
+				if req.GetBody == nil {
+					return nil, err
+				}
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		return resp, err
+	}
+}
+func (obj *http2Transport) getClientConn(req *http.Request, connKey string, addr string, spec H2Ja3Spec) (*http2ClientConn, error) {
+	for {
+		obj.mu.Lock()
+		for _, cc := range obj.conns[connKey] {
+			if cc.reserveNewRequest() {
+				obj.mu.Unlock()
+				return cc, nil
+			}
+		}
+		if obj.dialing == nil {
+			obj.dialing = make(map[string]*http2DialCall)
+		}
+		call, ok := obj.dialing[connKey]
+		if !ok {
+			call = &http2DialCall{done: make(chan struct{})}
+			obj.dialing[connKey] = call
+		}
+		obj.mu.Unlock()
+		if ok { //等待其它请求建立的连接
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-call.done:
+			}
+			if call.err != nil {
+				return nil, call.err
+			}
+			continue
+		}
+		cc, err := obj.dialClientConn(req, connKey, addr, spec)
+		obj.mu.Lock()
+		delete(obj.dialing, connKey)
+		if err == nil {
+			cc.reserveNewRequest()
+			if obj.conns == nil {
+				obj.conns = make(map[string][]*http2ClientConn)
+			}
+			obj.conns[connKey] = append(obj.conns[connKey], cc)
+		}
+		obj.mu.Unlock()
+		call.err = err
+		close(call.done)
+		return cc, err
+	}
+}
+func (obj *http2Transport) dialClientConn(req *http.Request, connKey string, addr string, spec H2Ja3Spec) (*http2ClientConn, error) {
+	var conn net.Conn
+	var err error
+	if req.URL.Scheme == "http" {
+		conn, err = obj.dialContext(req.Context(), "tcp", addr)
+	} else {
+		conn, err = obj.dialTlsContext(req.Context(), "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var proto string
+	switch tlsConn := conn.(type) {
+	case *tls.Conn:
+		if err = tlsConn.HandshakeContext(req.Context()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		proto = tlsConn.ConnectionState().NegotiatedProtocol
+	case *utls.UConn:
+		proto = tlsConn.ConnectionState().NegotiatedProtocol
+	default:
+		proto = "h2"
+	}
+	if proto != "h2" {
+		conn.Close()
+		return nil, ErrH2NotSupport
+	}
+	cc, err := obj.newClientConn(conn, connKey, spec)
+	if err != nil {
+		conn.Close()
+	}
+	return cc, err
+}
+func (obj *http2Transport) removeClientConn(cc *http2ClientConn) {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	ccs := obj.conns[cc.key]
+	for i, c := range ccs {
+		if c == cc {
+			ccs = append(ccs[:i], ccs[i+1:]...)
+			break
+		}
+	}
+	if len(ccs) == 0 {
+		delete(obj.conns, cc.key)
+	} else {
+		obj.conns[cc.key] = ccs
+	}
+}
+
+type http2ClientConn struct {
+	t     *http2Transport
+	key   string
+	tconn net.Conn
+	bw    *bufio.Writer
+	fr    *http2.Framer
+	hdec  *hpack.Decoder
+	spec  H2Ja3Spec
+
+	wmu  sync.Mutex //写锁,henc,hbuf,bw,fr 的写操作
+	henc *hpack.Encoder
+	hbuf bytes.Buffer
+
+	mu                   sync.Mutex //保护下面的字段
+	cond                 *sync.Cond //等待流量控制窗口
+	streams              map[uint32]*http2ClientStream
+	nextStreamID         uint32
+	reserved             int
+	maxConcurrentStreams uint32
+	maxFrameSize         uint32
+	initialWindowSize    int32 //对端的初始流窗口
+	flow                 int32 //连接的发送窗口
+	inflow               int32 //连接的接收窗口
+	unacked              int32 //已读取但未返回的连接窗口
+	connWindow           int32 //连接的接收窗口大小
+	streamWindow         int32 //流的接收窗口大小
+	pings                map[[8]byte]chan struct{}
+	goAway               *http2.GoAwayFrame
+	used                 bool
+	closed               bool
+	err                  error
+	idleTimer            *time.Timer
+	healthTimer          *time.Timer
+}
+
+type writeDeadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (obj *writeDeadlineConn) Write(p []byte) (int, error) {
+	if obj.timeout > 0 {
+		obj.Conn.SetWriteDeadline(time.Now().Add(obj.timeout))
+		defer obj.Conn.SetWriteDeadline(time.Time{})
+	}
+	return obj.Conn.Write(p)
+}
+
+func (obj *http2Transport) newClientConn(conn net.Conn, connKey string, spec H2Ja3Spec) (*http2ClientConn, error) {
+	if !spec.IsSet() {
+		spec, _ = CreateH2SpecWithName("golang")
+	}
+	cc := &http2ClientConn{
+		t:                    obj,
+		key:                  connKey,
+		tconn:                conn,
+		spec:                 spec,
+		streams:              make(map[uint32]*http2ClientStream),
+		nextStreamID:         1,
+		maxConcurrentStreams: 100,
+		maxFrameSize:         16384,
+		initialWindowSize:    65535,
+		flow:                 65535,
+		streamWindow:         65535,
+		pings:                make(map[[8]byte]chan struct{}),
+	}
+	cc.cond = sync.NewCond(&cc.mu)
+	cc.bw = bufio.NewWriterSize(&writeDeadlineConn{Conn: conn, timeout: obj.writeByteTimeout}, 16<<10)
+	cc.fr = http2.NewFramer(cc.bw, bufio.NewReaderSize(conn, 16<<10))
+	var headerTableSize uint32 = 4096
+	var maxHeaderListSize uint32 = 10 << 20
+	for _, setting := range spec.InitialSetting {
+		switch setting.ID {
+		case http2.SettingHeaderTableSize:
+			headerTableSize = setting.Val
+		case http2.SettingInitialWindowSize:
+			cc.streamWindow = int32(setting.Val)
+		case http2.SettingMaxFrameSize:
+			cc.fr.SetMaxReadFrameSize(setting.Val)
+		case http2.SettingMaxHeaderListSize:
+			maxHeaderListSize = setting.Val
+		}
+	}
+	cc.hdec = hpack.NewDecoder(headerTableSize, nil)
+	cc.fr.ReadMetaHeaders = cc.hdec
+	cc.fr.MaxHeaderListSize = maxHeaderListSize
+	cc.henc = hpack.NewEncoder(&cc.hbuf)
+	cc.connWindow = 65535 + int32(spec.ConnFlow)
+	cc.inflow = cc.connWindow
+	for _, priority := range spec.PriorityFrames { //优先级帧占用的流id,请求需要从后面开始
+		if priority.StreamID >= cc.nextStreamID {
+			cc.nextStreamID = priority.StreamID + 1
+			if cc.nextStreamID%2 == 0 {
+				cc.nextStreamID++
+			}
+		}
+	}
+	if _, err := cc.bw.WriteString(http2.ClientPreface); err != nil {
+		return nil, err
+	}
+	if err := cc.fr.WriteSettings(spec.InitialSetting...); err != nil {
+		return nil, err
+	}
+	if spec.ConnFlow > 0 {
+		if err := cc.fr.WriteWindowUpdate(0, spec.ConnFlow); err != nil {
+			return nil, err
+		}
+	}
+	for _, priority := range spec.PriorityFrames {
+		if err := cc.fr.WritePriority(priority.StreamID, priority.Priority); err != nil {
+			return nil, err
+		}
+	}
+	if err := cc.bw.Flush(); err != nil {
+		return nil, err
+	}
+	if obj.readIdleTimeout > 0 {
+		cc.healthTimer = time.AfterFunc(obj.readIdleTimeout, cc.healthCheck)
+	}
+	go cc.readLoop()
+	return cc, nil
+}
+
+// 预留一个新的流
+func (obj *http2ClientConn) reserveNewRequest() bool {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	if obj.closed || obj.goAway != nil || obj.nextStreamID >= 1<<31-1 {
+		return false
+	}
+	if uint32(len(obj.streams)+obj.reserved) >= obj.maxConcurrentStreams {
+		return false
+	}
+	if obj.t.disAlive && (obj.used || obj.reserved > 0) { //关闭长连接时每个连接只发送一个请求
+		return false
+	}
+	obj.reserved++
+	if obj.idleTimer != nil {
+		obj.idleTimer.Stop()
+	}
+	return true
+}
+func (obj *http2ClientConn) closeIfIdle() {
+	obj.mu.Lock()
+	idle := len(obj.streams) == 0 && obj.reserved == 0
+	obj.mu.Unlock()
+	if idle {
+		obj.closeWithError(errH2ConnClosed)
+	}
+}
+func (obj *http2ClientConn) closeWithError(err error) {
+	obj.mu.Lock()
+	if obj.closed {
+		obj.mu.Unlock()
+		return
+	}
+	obj.closed = true
+	obj.err = err
+	streams := make([]*http2ClientStream, 0, len(obj.streams))
+	for _, cs := range obj.streams {
+		streams = append(streams, cs)
+	}
+	if obj.idleTimer != nil {
+		obj.idleTimer.Stop()
+	}
+	if obj.healthTimer != nil {
+		obj.healthTimer.Stop()
+	}
+	obj.cond.Broadcast()
+	obj.mu.Unlock()
+	for _, cs := range streams {
+		if cs.canRetry() { //没有收到响应的幂等请求可以重试
+			cs.abort(fmt.Errorf("%w: %v", errH2Retry, err))
+		} else {
+			cs.abort(err)
+		}
+	}
+	obj.tconn.Close()
+	obj.t.removeClientConn(obj)
+}
+
+// 移除流,连接空闲时开始计时
+func (obj *http2ClientConn) forgetStream(id uint32) {
+	obj.mu.Lock()
+	delete(obj.streams, id)
+	idle := len(obj.streams) == 0 && obj.reserved == 0
+	if idle && !obj.closed {
+		if obj.t.disAlive || obj.goAway != nil {
+			obj.mu.Unlock()
+			obj.closeWithError(errH2ConnClosed)
+			return
+		}
+		if obj.t.idleConnTimeout > 0 {
+			if obj.idleTimer == nil {
+				obj.idleTimer = time.AfterFunc(obj.t.idleConnTimeout, obj.closeIfIdle)
+			} else {
+				obj.idleTimer.Reset(obj.t.idleConnTimeout)
+			}
+		}
+	}
+	obj.mu.Unlock()
+}
+func (obj *http2ClientConn) streamByID(id uint32) *http2ClientStream {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	return obj.streams[id]
+}
+
+// 检测连接是否健康
+func (obj *http2ClientConn) healthCheck() {
+	ctx, cnl := context.WithTimeout(context.TODO(), obj.t.pingTimeout)
+	defer cnl()
+	if err := obj.ping(ctx); err != nil {
+		obj.closeWithError(err)
+	}
+}
+func (obj *http2ClientConn) ping(ctx context.Context) error {
+	var data [8]byte
+	if _, err := rand.Read(data[:]); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	obj.mu.Lock()
+	obj.pings[data] = done
+	obj.mu.Unlock()
+	defer func() {
+		obj.mu.Lock()
+		delete(obj.pings, data)
+		obj.mu.Unlock()
+	}()
+	if err := obj.writeFrame(func() error { return obj.fr.WritePing(false, data) }); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+func (obj *http2ClientConn) writeFrame(write func() error) error {
+	obj.wmu.Lock()
+	defer obj.wmu.Unlock()
+	if err := write(); err != nil {
+		return err
+	}
+	return obj.bw.Flush()
+}
+
+// 编码请求头,必须持有wmu
+func (obj *http2ClientConn) encodeHeaders(req *http.Request, contentLength int64) []byte {
+	obj.hbuf.Reset()
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	path := req.URL.RequestURI()
+	if req.Method == http.MethodConnect {
+		path = ""
+	}
+	pseudos := map[string]string{
+		":method":    req.Method,
+		":authority": host,
+		":scheme":    req.URL.Scheme,
+		":path":      path,
+	}
+	pseudoOrder := obj.spec.PseudoHeaderOrder
+	if len(pseudoOrder) == 0 {
+		pseudoOrder = []string{":method", ":authority", ":scheme", ":path"}
+	}
+	for _, key := range pseudoOrder {
+		if val := pseudos[key]; val != "" {
+			obj.henc.WriteField(hpack.HeaderField{Name: key, Value: val})
+		}
+		delete(pseudos, key)
+	}
+	for _, key := range []string{":method", ":authority", ":scheme", ":path"} {
+		if val, ok := pseudos[key]; ok && val != "" {
+			obj.henc.WriteField(hpack.HeaderField{Name: key, Value: val})
+		}
+	}
+	var orderHeaders []string
+	if reqData, ok := req.Context().Value(keyPrincipalID).(*reqCtxData); ok {
+		orderHeaders = reqData.orderHeaders
+	}
+	for _, field := range h2HeaderFields(req.Header, contentLength, orderHeaders) {
+		obj.henc.WriteField(field)
+	}
+	return obj.hbuf.Bytes()
+}
+func h2HeaderFields(headers http.Header, contentLength int64, orderHeaders []string) []hpack.HeaderField {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(orderHeaders) > 0 { //指定顺序的请求头在前,其余的按字母顺序排在后面
+		orderIndex := make(map[string]int, len(orderHeaders))
+		for i, key := range orderHeaders {
+			key = strings.ToLower(key)
+			if _, ok := orderIndex[key]; !ok {
+				orderIndex[key] = i
+			}
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			iIndex, iOk := orderIndex[strings.ToLower(keys[i])]
+			jIndex, jOk := orderIndex[strings.ToLower(keys[j])]
+			if iOk && jOk {
+				return iIndex < jIndex
+			}
+			return iOk
+		})
+	}
+	fields := []hpack.HeaderField{}
+	var hasLength bool
+	for _, key := range keys {
+		name := strings.ToLower(key)
+		if h2ConnHeaders[name] {
+			continue
+		}
+		if name == "content-length" {
+			hasLength = true
+		}
+		for _, val := range headers[key] {
+			if name == "te" && val != "trailers" {
+				continue
+			}
+			fields = append(fields, hpack.HeaderField{Name: name, Value: val})
+		}
+	}
+	if !hasLength && contentLength > 0 {
+		fields = append(fields, hpack.HeaderField{Name: "content-length", Value: strconv.FormatInt(contentLength, 10)})
+	}
+	return fields
+}
+
+// 写入请求头,必须持有wmu
+func (obj *http2ClientConn) writeHeaders(streamID uint32, endStream bool, hdrs []byte, maxFrameSize uint32) error {
+	first := true
+	for first || len(hdrs) > 0 {
+		chunk := hdrs
+		if len(chunk) > int(maxFrameSize) {
+			chunk = chunk[:maxFrameSize]
+		}
+		hdrs = hdrs[len(chunk):]
+		endHeaders := len(hdrs) == 0
+		if first {
+			if err := obj.fr.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      streamID,
+				BlockFragment: chunk,
+				EndStream:     endStream,
+				EndHeaders:    endHeaders,
+				Priority:      obj.spec.Priority,
+			}); err != nil {
+				return err
+			}
+			first = false
+		} else if err := obj.fr.WriteContinuation(streamID, endHeaders, chunk); err != nil {
+			return err
+		}
+	}
+	return obj.bw.Flush()
+}
+
+func (obj *http2ClientConn) roundTrip(req *http.Request) (*http.Response, error) {
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	cs := &http2ClientStream{
+		cc:   obj,
+		req:  req,
+		resc: make(chan http2ResResult, 1),
+		done: make(chan struct{}),
+	}
+	cs.body = &http2Pipe{onRead: cs.onRead}
+	cs.body.cond.L = &cs.body.mu
+	obj.wmu.Lock()
+	obj.mu.Lock()
+	obj.reserved--
+	if obj.closed || obj.goAway != nil {
+		obj.mu.Unlock()
+		obj.wmu.Unlock()
+		return nil, errH2Retry
+	}
+	cs.id = obj.nextStreamID
+	obj.nextStreamID += 2
+	obj.used = true
+	cs.flow = obj.initialWindowSize
+	cs.inflow = obj.streamWindow
+	obj.streams[cs.id] = cs
+	maxFrameSize := obj.maxFrameSize
+	obj.mu.Unlock()
+	err := obj.writeHeaders(cs.id, !hasBody, obj.encodeHeaders(req, req.ContentLength), maxFrameSize)
+	obj.wmu.Unlock()
+	if err != nil {
+		obj.closeWithError(err)
+		return nil, err
+	}
+	if hasBody {
+		go cs.writeBody(req.Body)
+	}
+	select {
+	case res := <-cs.resc:
+		if res.err != nil {
+			return nil, res.err
+		}
+		go cs.watchCtx()
+		return res.resp, nil
+	case <-req.Context().Done():
+		cs.reset(http2.ErrCodeCancel, req.Context().Err())
+		return nil, req.Context().Err()
+	}
+}
+
+type http2ResResult struct {
+	resp *http.Response
+	err  error
+}
+type http2ClientStream struct {
+	cc         *http2ClientConn
+	id         uint32
+	req        *http.Request
+	resp       *http.Response
+	resc       chan http2ResResult
+	body       *http2Pipe
+	flow       int32       //发送窗口,cc.mu
+	inflow     int32       //接收窗口,cc.mu
+	unacked    int32       //已读取但未返回的窗口,cc.mu
+	gotHeaders bool        //是否收到响应头,cc.mu
+	endStream  bool        //对端已结束,cc.mu
+	trailer    http.Header //cc.mu
+	err        error       //cc.mu
+	doneOnce   sync.Once
+	done       chan struct{}
+}
+
+func (obj *http2ClientStream) canRetry() bool {
+	obj.cc.mu.Lock()
+	gotHeaders := obj.gotHeaders
+	obj.cc.mu.Unlock()
+	if gotHeaders || (obj.req.Body != nil && obj.req.Body != http.NoBody) {
+		return false
+	}
+	switch obj.req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+func (obj *http2ClientStream) watchCtx() {
+	select {
+	case <-obj.req.Context().Done():
+		obj.reset(http2.ErrCodeCancel, obj.req.Context().Err())
+	case <-obj.done:
+	}
+}
+
+// 结束流,不发送RST_STREAM
+func (obj *http2ClientStream) abort(err error) {
+	obj.cc.mu.Lock()
+	if obj.err == nil {
+		obj.err = err
+	}
+	obj.cc.cond.Broadcast()
+	obj.cc.mu.Unlock()
+	obj.doneOnce.Do(func() { close(obj.done) })
+	obj.sendResult(http2ResResult{err: err})
+	if n := obj.body.closeWithError(err, true, nil); n > 0 { //丢弃的数据返回连接窗口
+		obj.cc.returnFlow(int32(n))
+	}
+	obj.cc.forgetStream(obj.id)
+}
+
+func (obj *http2ClientStream) sendResult(res http2ResResult) {
+	select {
+	case obj.resc <- res:
+	default:
+	}
+}
+
+// 结束流,并发送RST_STREAM
+func (obj *http2ClientStream) reset(code http2.ErrCode, err error) {
+	obj.cc.mu.Lock()
+	ended := obj.endStream || obj.err != nil
+	obj.cc.mu.Unlock()
+	if !ended {
+		obj.cc.writeFrame(func() error { return obj.cc.fr.WriteRSTStream(obj.id, code) })
+	}
+	obj.abort(err)
+}
+
+// 对端结束流
+func (obj *http2ClientStream) end() {
+	obj.cc.mu.Lock()
+	obj.endStream = true
+	obj.cc.cond.Broadcast()
+	obj.cc.mu.Unlock()
+	obj.doneOnce.Do(func() { close(obj.done) })
+	obj.body.closeWithError(io.EOF, false, obj.copyTrailer)
+	obj.cc.forgetStream(obj.id)
+}
+
+// 在读取到EOF 之前设置响应的trailer
+func (obj *http2ClientStream) copyTrailer() {
+	obj.cc.mu.Lock()
+	trailer := obj.trailer
+	obj.cc.mu.Unlock()
+	if trailer != nil && obj.resp != nil {
+		obj.resp.Trailer = trailer
+	}
+}
+
+// 等待发送窗口
+func (obj *http2ClientStream) awaitFlow(size int) (int, error) {
+	cc := obj.cc
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for {
+		if obj.err != nil {
+			return 0, obj.err
+		}
+		if obj.endStream { //对端已经返回完整的响应,不需要再发送请求体
+			return 0, errH2ClosedBody
+		}
+		if cc.closed {
+			return 0, errH2ConnClosed
+		}
+		take := int32(size)
+		if take > obj.flow {
+			take = obj.flow
+		}
+		if take > cc.flow {
+			take = cc.flow
+		}
+		if take > int32(cc.maxFrameSize) {
+			take = int32(cc.maxFrameSize)
+		}
+		if take > 0 {
+			obj.flow -= take
+			cc.flow -= take
+			return int(take), nil
+		}
+		cc.cond.Wait()
+	}
+}
+func (obj *http2ClientStream) writeBody(body io.ReadCloser) {
+	defer body.Close()
+	buf := make([]byte, 16384)
+	for {
+		n, rerr := body.Read(buf)
+		data := buf[:n]
+		for len(data) > 0 {
+			take, err := obj.awaitFlow(len(data))
+			if err != nil {
+				if err != errH2ClosedBody {
+					obj.reset(http2.ErrCodeCancel, err)
+				}
+				return
+			}
+			if err = obj.cc.writeFrame(func() error { return obj.cc.fr.WriteData(obj.id, false, data[:take]) }); err != nil {
+				obj.cc.closeWithError(err)
+				return
+			}
+			data = data[take:]
+		}
+		if rerr == io.EOF {
+			if err := obj.cc.writeFrame(func() error { return obj.cc.fr.WriteData(obj.id, true, nil) }); err != nil {
+				obj.cc.closeWithError(err)
+			}
+			return
+		} else if rerr != nil {
+			obj.reset(http2.ErrCodeCancel, rerr)
+			return
+		}
+	}
+}
+
+// 读取响应体后返回窗口
+func (obj *http2ClientStream) onRead(n int) {
+	cc := obj.cc
+	var connAdd, streamAdd int32
+	cc.mu.Lock()
+	cc.unacked += int32(n)
+	if cc.unacked >= cc.connWindow/2 {
+		connAdd = cc.unacked
+		cc.inflow += connAdd
+		cc.unacked = 0
+	}
+	if !obj.endStream && obj.err == nil {
+		obj.unacked += int32(n)
+		if obj.unacked >= cc.streamWindow/2 {
+			streamAdd = obj.unacked
+			obj.inflow += streamAdd
+			obj.unacked = 0
+		}
+	}
+	cc.mu.Unlock()
+	if connAdd > 0 || streamAdd > 0 {
+		cc.writeFrame(func() error {
+			if connAdd > 0 {
+				if err := cc.fr.WriteWindowUpdate(0, uint32(connAdd)); err != nil {
+					return err
+				}
+			}
+			if streamAdd > 0 {
+				return cc.fr.WriteWindowUpdate(obj.id, uint32(streamAdd))
+			}
+			return nil
+		})
+	}
+}
+
+type http2Pipe struct {
+	mu       sync.Mutex
+	cond     sync.Cond
+	buf      bytes.Buffer
+	err      error
+	breakErr error
+	onRead   func(int)
+}
+
+func (obj *http2Pipe) Write(p []byte) (int, error) {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	if obj.err != nil || obj.breakErr != nil {
+		return 0, errH2ClosedBody
+	}
+	defer obj.cond.Broadcast()
+	return obj.buf.Write(p)
+}
+func (obj *http2Pipe) Read(p []byte) (int, error) {
+	obj.mu.Lock()
+	for obj.buf.Len() == 0 && obj.err == nil && obj.breakErr == nil {
+		obj.cond.Wait()
+	}
+	if obj.breakErr != nil {
+		obj.mu.Unlock()
+		return 0, obj.breakErr
+	}
+	if obj.buf.Len() > 0 {
+		n, _ := obj.buf.Read(p)
+		obj.mu.Unlock()
+		if obj.onRead != nil {
+			obj.onRead(n)
+		}
+		return n, nil
+	}
+	obj.mu.Unlock()
+	return 0, obj.err
+}
+
+// 关闭管道,isBreak 为true 时丢弃未读取的数据,返回丢弃的长度,fn 在读取端收到错误之前调用
+func (obj *http2Pipe) closeWithError(err error, isBreak bool, fn func()) int {
+	obj.mu.Lock()
+	defer obj.mu.Unlock()
+	var n int
+	if isBreak {
+		if obj.breakErr == nil {
+			obj.breakErr = err
+		}
+		n = obj.buf.Len()
+		obj.buf.Reset()
+	} else if obj.err == nil {
+		if fn != nil {
+			fn()
+		}
+		obj.err = err
+	}
+	obj.cond.Broadcast()
+	return n
+}
+
+type http2ResponseBody struct {
+	cs *http2ClientStream
+}
+
+func (obj *http2ResponseBody) Read(p []byte) (int, error) {
+	return obj.cs.body.Read(p)
+}
+func (obj *http2ResponseBody) Close() error {
+	obj.cs.reset(http2.ErrCodeCancel, errH2ClosedBody)
+	return nil
+}
+
+func (obj *http2ClientConn) readLoop() {
+	var err error
+	defer func() {
+		obj.closeWithError(err)
+	}()
+	var pushPromise uint32
+	for {
+		var f http2.Frame
+		if f, err = obj.fr.ReadFrame(); err != nil {
+			if se, ok := err.(http2.StreamError); ok {
+				if cs := obj.streamByID(se.StreamID); cs != nil {
+					cs.reset(se.Code, se)
+				}
+				err = nil
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				err = errH2ConnClosed
+			}
+			return
+		}
+		if obj.healthTimer != nil {
+			obj.healthTimer.Reset(obj.t.readIdleTimeout)
+		}
+		switch f := f.(type) {
+		case *http2.MetaHeadersFrame:
+			err = obj.processHeaders(f)
+		case *http2.DataFrame:
+			err = obj.processData(f)
+		case *http2.SettingsFrame:
+			err = obj.processSettings(f)
+		case *http2.WindowUpdateFrame:
+			obj.mu.Lock()
+			if f.StreamID == 0 {
+				obj.flow += int32(f.Increment)
+			} else if cs := obj.streams[f.StreamID]; cs != nil {
+				cs.flow += int32(f.Increment)
+			}
+			obj.cond.Broadcast()
+			obj.mu.Unlock()
+		case *http2.PingFrame:
+			if f.IsAck() {
+				obj.mu.Lock()
+				if done, ok := obj.pings[f.Data]; ok {
+					close(done)
+					delete(obj.pings, f.Data)
+				}
+				obj.mu.Unlock()
+			} else {
+				err = obj.writeFrame(func() error { return obj.fr.WritePing(true, f.Data) })
+			}
+		case *http2.RSTStreamFrame:
+			if cs := obj.streamByID(f.StreamID); cs != nil {
+				rstErr := fmt.Errorf("%w: %v", errH2StreamReset, f.ErrCode)
+				if f.ErrCode == http2.ErrCodeRefusedStream {
+					rstErr = fmt.Errorf("%w: %v", errH2Retry, f.ErrCode)
+				}
+				cs.abort(rstErr)
+			}
+		case *http2.GoAwayFrame:
+			obj.processGoAway(f)
+		case *http2.PushPromiseFrame: //不接受服务端推送,解码header 保持hpack 状态一致
+			obj.hdec.SetEmitFunc(func(hf hpack.HeaderField) {})
+			if _, err = obj.hdec.Write(f.HeaderBlockFragment()); err != nil {
+				return
+			}
+			if f.HeadersEnded() {
+				if err = obj.hdec.Close(); err != nil {
+					return
+				}
+			} else {
+				pushPromise = f.PromiseID
+			}
+			promiseID := f.PromiseID
+			err = obj.writeFrame(func() error { return obj.fr.WriteRSTStream(promiseID, http2.ErrCodeRefusedStream) })
+		case *http2.ContinuationFrame:
+			if pushPromise == 0 {
+				err = errors.New("http2: unexpected continuation frame")
+				return
+			}
+			if _, err = obj.hdec.Write(f.HeaderBlockFragment()); err != nil {
+				return
+			}
+			if f.HeadersEnded() {
+				pushPromise = 0
+				err = obj.hdec.Close()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+func (obj *http2ClientConn) processHeaders(f *http2.MetaHeadersFrame) error {
+	cs := obj.streamByID(f.StreamID)
+	if cs == nil { //流已经被关闭
+		return nil
+	}
+	obj.mu.Lock()
+	gotHeaders := cs.gotHeaders
+	obj.mu.Unlock()
+	if gotHeaders { //trailers
+		if !f.StreamEnded() {
+			cs.reset(http2.ErrCodeProtocol, errors.New("http2: trailers without end stream"))
+			return nil
+		}
+		trailer := make(http.Header)
+		for _, field := range f.RegularFields() {
+			trailer.Add(textproto.CanonicalMIMEHeaderKey(field.Name), field.Value)
+		}
+		obj.mu.Lock()
+		cs.trailer = trailer
+		obj.mu.Unlock()
+		cs.end()
+		return nil
+	}
+	status := f.PseudoValue("status")
+	statusCode, err := strconv.Atoi(status)
+	if err != nil {
+		cs.reset(http2.ErrCodeProtocol, errors.New("http2: malformed response status"))
+		return nil
+	}
+	if statusCode >= 100 && statusCode <= 199 { //忽略1xx 响应
+		if f.StreamEnded() {
+			cs.reset(http2.ErrCodeProtocol, errors.New("http2: 1xx response with end stream"))
+		}
+		return nil
+	}
+	obj.mu.Lock()
+	cs.gotHeaders = true
+	obj.mu.Unlock()
+	header := make(http.Header)
+	for _, field := range f.RegularFields() {
+		header.Add(textproto.CanonicalMIMEHeaderKey(field.Name), field.Value)
+	}
+	resp := &http.Response{
+		Status:        status + " " + http.StatusText(statusCode),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        header,
+		ContentLength: -1,
+		Request:       cs.req,
+	}
+	if tlsConn, ok := obj.tconn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		resp.TLS = &state
+	}
+	if contentLength := header.Get("Content-Length"); contentLength != "" {
+		if length, err := strconv.ParseInt(contentLength, 10, 64); err == nil {
+			resp.ContentLength = length
+		}
+	}
+	cs.resp = resp
+	if f.StreamEnded() || cs.req.Method == http.MethodHead {
+		if f.StreamEnded() {
+			resp.ContentLength = 0
+		}
+		resp.Body = http.NoBody
+		cs.sendResult(http2ResResult{resp: resp})
+		if f.StreamEnded() {
+			cs.end()
+		} else {
+			cs.reset(http2.ErrCodeCancel, errH2ClosedBody)
+		}
+		return nil
+	}
+	resp.Body = &http2ResponseBody{cs: cs}
+	cs.sendResult(http2ResResult{resp: resp})
+	return nil
+}
+func (obj *http2ClientConn) processData(f *http2.DataFrame) error {
+	length := int32(f.Length)
+	obj.mu.Lock()
+	if obj.inflow < length {
+		obj.mu.Unlock()
+		return errors.New("http2: connection flow control error")
+	}
+	obj.inflow -= length
+	cs := obj.streams[f.StreamID]
+	if cs != nil {
+		if cs.inflow < length {
+			obj.mu.Unlock()
+			cs.reset(http2.ErrCodeFlowControl, errors.New("http2: stream flow control error"))
+			obj.returnFlow(length)
+			return nil
+		}
+		cs.inflow -= length
+	}
+	gotHeaders := cs != nil && cs.gotHeaders
+	obj.mu.Unlock()
+	if !gotHeaders { //流已经被关闭,返回连接窗口
+		obj.returnFlow(length)
+		return nil
+	}
+	data := f.Data()
+	if pad := length - int32(len(data)); pad > 0 { //填充的数据直接返回窗口
+		obj.returnFlow(pad)
+	}
+	if len(data) > 0 {
+		if _, err := cs.body.Write(data); err != nil {
+			obj.returnFlow(int32(len(data)))
+		}
+	}
+	if f.StreamEnded() {
+		cs.end()
+	}
+	return nil
+}
+
+// 返回连接窗口
+func (obj *http2ClientConn) returnFlow(n int32) {
+	var connAdd int32
+	obj.mu.Lock()
+	obj.unacked += n
+	if obj.unacked >= obj.connWindow/2 {
+		connAdd = obj.unacked
+		obj.inflow += connAdd
+		obj.unacked = 0
+	}
+	obj.mu.Unlock()
+	if connAdd > 0 {
+		obj.writeFrame(func() error { return obj.fr.WriteWindowUpdate(0, uint32(connAdd)) })
+	}
+}
+func (obj *http2ClientConn) processSettings(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	var headerTableSize *uint32
+	obj.mu.Lock()
+	err := f.ForeachSetting(func(setting http2.Setting) error {
+		switch setting.ID {
+		case http2.SettingMaxFrameSize:
+			obj.maxFrameSize = setting.Val
+		case http2.SettingMaxConcurrentStreams:
+			obj.maxConcurrentStreams = setting.Val
+		case http2.SettingInitialWindowSize:
+			if setting.Val > 1<<31-1 {
+				return errors.New("http2: invalid initial window size")
+			}
+			delta := int32(setting.Val) - obj.initialWindowSize
+			for _, cs := range obj.streams {
+				cs.flow += delta
+			}
+			obj.initialWindowSize = int32(setting.Val)
+		case http2.SettingHeaderTableSize:
+			val := setting.Val
+			headerTableSize = &val
+		}
+		return nil
+	})
+	obj.cond.Broadcast()
+	obj.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return obj.writeFrame(func() error {
+		if headerTableSize != nil {
+			obj.henc.SetMaxDynamicTableSizeLimit(*headerTableSize)
+		}
+		return obj.fr.WriteSettingsAck()
+	})
+}
+func (obj *http2ClientConn) processGoAway(f *http2.GoAwayFrame) {
+	obj.mu.Lock()
+	obj.goAway = f
+	streams := []*http2ClientStream{}
+	for id, cs := range obj.streams {
+		if id > f.LastStreamID {
+			streams = append(streams, cs)
+		}
+	}
+	idle := len(obj.streams) == 0 && obj.reserved == 0
+	obj.mu.Unlock()
+	obj.t.removeClientConn(obj)
+	for _, cs := range streams { //未被处理的流可以重试
+		cs.abort(fmt.Errorf("%w: server sent GOAWAY", errH2Retry))
+	}
+	if idle {
+		obj.closeWithError(errH2ConnClosed)
+	}
+}
@@ -0,0 +1,493 @@
+package requests
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitee.com/baixudong/gospider/tools"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+type altSvc struct {
+	addr   string    //h3 服务的地址
+	expire time.Time //过期时间
+}
+
+// http3 transport,不支持ja3 指纹,代理只支持socks5(UDP ASSOCIATE)
+type http3Transport struct {
+	dialCli             *dialClient
+	fallback            http.RoundTripper //自动升级时,没有alt-svc 的请求使用的transport
+	tlsHandshakeTimeout time.Duration
+	idleConnTimeout     time.Duration
+	keepAlive           time.Duration
+	disAlive            bool
+
+	altSvcs *sync.Map //alt-svc 缓存,key:源站的host:port
+
+	lock       sync.Mutex
+	transports map[string]*http3Entry //key:代理
+	clearTime  time.Time              //上次清理空闲transport 的时间
+}
+
+// 代理对应的transport,空闲超时后关闭,GetProxy 轮换代理时不会无限增长
+type http3Entry struct {
+	transport *http3.RoundTripper
+	active    int       //正在使用的请求数,lock
+	lastUsed  time.Time //最后使用的时间,lock
+}
+
+func newHttp3Transport(ctx context.Context, session_option ClientOption, dialCli *dialClient, fallback http.RoundTripper) *http3Transport {
+	return &http3Transport{
+		dialCli:             dialCli,
+		fallback:            fallback,
+		tlsHandshakeTimeout: time.Second * time.Duration(session_option.TLSHandshakeTimeout),
+		idleConnTimeout:     time.Second * time.Duration(session_option.IdleConnTimeout),
+		keepAlive:           time.Second * time.Duration(session_option.KeepAlive),
+		disAlive:            session_option.DisAlive,
+		altSvcs:             &sync.Map{},
+		transports:          map[string]*http3Entry{},
+	}
+}
+func (obj *http3Transport) clone() *http3Transport {
+	return &http3Transport{
+		dialCli:             obj.dialCli,
+		fallback:            obj.fallback,
+		tlsHandshakeTimeout: obj.tlsHandshakeTimeout,
+		idleConnTimeout:     obj.idleConnTimeout,
+		keepAlive:           obj.keepAlive,
+		disAlive:            obj.disAlive,
+		altSvcs:             obj.altSvcs,
+		transports:          map[string]*http3Entry{},
+	}
+}
+
+// 源站的host:port
+func authorityAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "http" {
+		return net.JoinHostPort(u.Hostname(), "80")
+	}
+	return net.JoinHostPort(u.Hostname(), "443")
+}
+
+// 根据响应头中的alt-svc 记录h3 服务的地址
+func (obj *http3Transport) setAltSvc(u *url.URL, value string) {
+	if u == nil || u.Scheme != "https" || value == "" {
+		return
+	}
+	origin := authorityAddr(u)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "clear" {
+			obj.altSvcs.Delete(origin)
+			return
+		}
+		params := strings.Split(item, ";")
+		proto, authority, ok := strings.Cut(params[0], "=")
+		if !ok || strings.TrimSpace(proto) != "h3" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(strings.Trim(strings.TrimSpace(authority), `"`))
+		if err != nil {
+			continue
+		}
+		if host == "" {
+			host = u.Hostname()
+		}
+		maxAge := 86400
+		for _, param := range params[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key == "ma" {
+				if ma, err := strconv.Atoi(val); err == nil {
+					maxAge = ma
+				}
+			}
+		}
+		obj.altSvcs.Store(origin, altSvc{addr: net.JoinHostPort(host, port), expire: time.Now().Add(time.Duration(maxAge) * time.Second)})
+		return
+	}
+}
+func (obj *http3Transport) getAltSvc(origin string) (string, bool) {
+	val, ok := obj.altSvcs.Load(origin)
+	if !ok {
+		return "", false
+	}
+	svc := val.(altSvc)
+	if time.Now().After(svc.expire) {
+		obj.altSvcs.Delete(origin)
+		return "", false
+	}
+	return svc.addr, true
+}
+func (obj *http3Transport) delAltSvc(u *url.URL) {
+	obj.altSvcs.Delete(authorityAddr(u))
+}
+
+// 是否有可用的h3 服务
+func (obj *http3Transport) hasAltSvc(u *url.URL) bool {
+	if u.Scheme != "https" {
+		return false
+	}
+	_, ok := obj.getAltSvc(authorityAddr(u))
+	return ok
+}
+func (obj *http3Transport) newRoundTripper() *http3.RoundTripper {
+	return &http3.RoundTripper{
+		TLSClientConfig: obj.dialCli.tls.tlsConfig(""),
+		QuicConfig: &quic.Config{
+			HandshakeIdleTimeout: obj.tlsHandshakeTimeout,
+			MaxIdleTimeout:       obj.idleConnTimeout,
+			KeepAlivePeriod:      obj.keepAlive,
+		},
+		Dial: obj.dial,
+	}
+}
+func (obj *http3Transport) dial(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	reqData := ctx.Value(keyPrincipalID).(*reqCtxData)
+	if svcAddr, ok := obj.getAltSvc(addr); ok {
+		addr = svcAddr
+	}
+	ipAddr, err := obj.dialCli.addrToIp(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", ipAddr)
+	if err != nil {
+		return nil, err
+	}
+	var packetConn net.PacketConn
+	if reqData.proxy == nil {
+		var localAddr *net.UDPAddr
+		if obj.dialCli.localAddrs != nil {
+			host, _, _ := net.SplitHostPort(addr)
+			if localIp := obj.dialCli.localAddrs.Get(localAddrHost(ctx, host), udpAddr.IP); localIp != nil {
+				localAddr = &net.UDPAddr{IP: localIp}
+			}
+		} else if tcpAddr, ok := obj.dialCli.dialer.LocalAddr.(*net.TCPAddr); ok {
+			localAddr = &net.UDPAddr{IP: tcpAddr.IP}
+		}
+		packetConn, err = net.ListenUDP("udp", localAddr)
+	} else if reqData.proxy.Scheme == "socks5" {
+		packetConn, err = newSocks5UdpConn(ctx, obj.dialCli.dialer, reqData.proxy)
+	} else {
+		err = tools.WrapError(errFatal, "http3 只支持socks5 代理")
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn, err := quic.DialEarly(ctx, packetConn, udpAddr, tlsCfg, cfg)
+	if err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		packetConn.Close()
+	}()
+	return conn, nil
+}
+
+type http3Body struct {
+	io.ReadCloser
+	onClose func()
+	once    sync.Once
+}
+
+func (obj *http3Body) Close() error {
+	err := obj.ReadCloser.Close()
+	obj.once.Do(obj.onClose)
+	return err
+}
+func (obj *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqData, ok := req.Context().Value(keyPrincipalID).(*reqCtxData)
+	if !ok {
+		return nil, tools.WrapError(errFatal, "not found reqData")
+	}
+	if req.URL.Scheme != "https" || (!reqData.h3 && !obj.hasAltSvc(req.URL)) { //http3 只支持https,自动升级时没有alt-svc 的使用原来的transport
+		return obj.fallback.RoundTrip(req)
+	}
+	if !reqData.h3 && reqData.ja3 { //http3 不支持ja3 指纹,不自动升级
+		return obj.fallback.RoundTrip(req)
+	}
+	reqData.url = req.URL
+	proxyUrl, err := obj.dialCli.getProxyUrl(req.Context(), reqData)
+	if err != nil {
+		return nil, err
+	}
+	reqData.proxy = proxyUrl
+	if !reqData.h3 && proxyUrl != nil && proxyUrl.Scheme != "socks5" { //http3 只支持socks5 代理,不自动升级
+		return obj.fallback.RoundTrip(req)
+	}
+	resp, err := obj.roundTrip(req, proxyUrl)
+	if err != nil && !reqData.h3 && req.Context().Err() == nil { //自动升级失败后不再使用http3,使用原来的transport 重试一次
+		obj.delAltSvc(req.URL)
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		return obj.fallback.RoundTrip(req)
+	}
+	return resp, err
+}
+func (obj *http3Transport) roundTrip(req *http.Request, proxyUrl *url.URL) (*http.Response, error) {
+	if obj.disAlive {
+		transport := obj.newRoundTripper()
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			transport.Close()
+			return resp, err
+		}
+		resp.Body = &http3Body{ReadCloser: resp.Body, onClose: func() { transport.Close() }}
+		return resp, err
+	}
+	var key string
+	if proxyUrl != nil {
+		key = proxyUrl.String()
+	}
+	entry := obj.getEntry(key)
+	resp, err := entry.transport.RoundTrip(req)
+	if err != nil {
+		obj.releaseEntry(entry)
+		return resp, err
+	}
+	resp.Body = &http3Body{ReadCloser: resp.Body, onClose: func() { obj.releaseEntry(entry) }}
+	return resp, err
+}
+
+// 获取代理对应的transport,同时关闭空闲超时的transport
+func (obj *http3Transport) getEntry(key string) *http3Entry {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	now := time.Now()
+	if now.Sub(obj.clearTime) > obj.idleConnTimeout {
+		for k, entry := range obj.transports {
+			if entry.active == 0 && now.Sub(entry.lastUsed) > obj.idleConnTimeout {
+				entry.transport.Close()
+				delete(obj.transports, k)
+			}
+		}
+		obj.clearTime = now
+	}
+	entry, ok := obj.transports[key]
+	if !ok {
+		entry = &http3Entry{transport: obj.newRoundTripper()}
+		obj.transports[key] = entry
+	}
+	entry.active++
+	entry.lastUsed = now
+	return entry
+}
+func (obj *http3Transport) releaseEntry(entry *http3Entry) {
+	obj.lock.Lock()
+	entry.active--
+	entry.lastUsed = time.Now()
+	obj.lock.Unlock()
+}
+func (obj *http3Transport) CloseIdleConnections() {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	for _, entry := range obj.transports {
+		entry.transport.CloseIdleConnections()
+	}
+}
+
+// socks5 UDP ASSOCIATE,通过socks5 代理转发udp 数据
+type socks5UdpConn struct {
+	net.PacketConn
+	tcpConn   net.Conn //控制连接,关闭后代理会停止转发
+	relayAddr net.Addr //代理的udp 中继地址
+	buf       []byte   //读缓存,quic 只在一个协程中读取
+}
+
+func newSocks5UdpConn(ctx context.Context, dialer *net.Dialer, proxyUrl *url.URL) (*socks5UdpConn, error) {
+	tcpConn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(proxyUrl.Hostname(), proxyUrl.Port()))
+	if err != nil {
+		return nil, err
+	}
+	relayAddr, err := socks5UdpAssociate(ctx, tcpConn, proxyUrl)
+	if err != nil {
+		tcpConn.Close()
+		return nil, err
+	}
+	if relayAddr.IP.IsUnspecified() { //代理返回0.0.0.0 时使用代理的地址
+		relayAddr.IP = tcpConn.RemoteAddr().(*net.TCPAddr).IP
+	}
+	var localAddr *net.UDPAddr
+	if tcpAddr, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok {
+		localAddr = &net.UDPAddr{IP: tcpAddr.IP}
+	}
+	packetConn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		tcpConn.Close()
+		return nil, err
+	}
+	conn := &socks5UdpConn{
+		PacketConn: packetConn,
+		tcpConn:    tcpConn,
+		relayAddr:  relayAddr,
+		buf:        make([]byte, 65535),
+	}
+	go func() { //控制连接断开后关闭udp
+		io.Copy(io.Discard, tcpConn)
+		conn.Close()
+	}()
+	return conn, nil
+}
+
+// 发送 UDP ASSOCIATE 命令,返回代理的udp 中继地址
+func socks5UdpAssociate(ctx context.Context, conn net.Conn, proxyUrl *url.URL) (*net.UDPAddr, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	methods := []byte{0x05, 0x01, 0x00}
+	if proxyUrl.User != nil {
+		methods = []byte{0x05, 0x02, 0x00, 0x02}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	if buf[0] != 0x05 {
+		return nil, errors.New("socks5 版本错误")
+	}
+	switch buf[1] {
+	case 0x00:
+	case 0x02:
+		if proxyUrl.User == nil {
+			return nil, errors.New("socks5 需要认证")
+		}
+		username := proxyUrl.User.Username()
+		password, _ := proxyUrl.User.Password()
+		auth := []byte{0x01, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		if buf[1] != 0x00 {
+			return nil, errors.New("socks5 认证失败")
+		}
+	default:
+		return nil, errors.New("socks5 不支持的认证方式")
+	}
+	if _, err := conn.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return nil, err
+	}
+	if buf[1] != 0x00 {
+		return nil, errors.New("socks5 UDP ASSOCIATE 失败,code:" + strconv.Itoa(int(buf[1])))
+	}
+	var ip net.IP
+	switch buf[3] {
+	case 0x01:
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return nil, err
+		}
+		ip = net.IP(append([]byte{}, buf[:4]...))
+	case 0x04:
+		if _, err := io.ReadFull(conn, buf[:16]); err != nil {
+			return nil, err
+		}
+		ip = net.IP(append([]byte{}, buf[:16]...))
+	case 0x03:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, err
+		}
+		host := buf[1 : 1+int(buf[0])]
+		if _, err := io.ReadFull(conn, host); err != nil {
+			return nil, err
+		}
+		ips, err := net.LookupIP(string(host))
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+	default:
+		return nil, errors.New("socks5 地址类型错误")
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(buf[:2]))}, nil
+}
+func (obj *socks5UdpConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("socks5 udp 地址错误")
+	}
+	packet := make([]byte, 0, len(p)+22)
+	if ip4 := udpAddr.IP.To4(); ip4 != nil {
+		packet = append(packet, 0, 0, 0, 0x01)
+		packet = append(packet, ip4...)
+	} else {
+		packet = append(packet, 0, 0, 0, 0x04)
+		packet = append(packet, udpAddr.IP.To16()...)
+	}
+	packet = binary.BigEndian.AppendUint16(packet, uint16(udpAddr.Port))
+	packet = append(packet, p...)
+	if _, err := obj.PacketConn.WriteTo(packet, obj.relayAddr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+func (obj *socks5UdpConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, _, err := obj.PacketConn.ReadFrom(obj.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		packet := obj.buf[:n]
+		if len(packet) < 4 || packet[2] != 0 { //不支持分片
+			continue
+		}
+		var addr *net.UDPAddr
+		switch packet[3] {
+		case 0x01:
+			if len(packet) < 10 {
+				continue
+			}
+			addr = &net.UDPAddr{IP: net.IP(append([]byte{}, packet[4:8]...)), Port: int(binary.BigEndian.Uint16(packet[8:10]))}
+			packet = packet[10:]
+		case 0x04:
+			if len(packet) < 22 {
+				continue
+			}
+			addr = &net.UDPAddr{IP: net.IP(append([]byte{}, packet[4:20]...)), Port: int(binary.BigEndian.Uint16(packet[20:22]))}
+			packet = packet[22:]
+		default:
+			continue
+		}
+		return copy(p, packet), addr, nil
+	}
+}
+func (obj *socks5UdpConn) Close() error {
+	obj.tcpConn.Close()
+	return obj.PacketConn.Close()
+}
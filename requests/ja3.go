@@ -0,0 +1,477 @@
+package requests
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// grease 占位符,放在 CipherSuites,Extensions,Curves 中表示随机的grease值
+const GreaseValue uint16 = utls.GREASE_PLACEHOLDER
+
+// ja3 指纹,可以通过 CreateJa3SpecWithStr,CreateJa3SpecWithId,CreateJa3SpecWithName 生成,也可以直接填写字段自定义ClientHello
+type Ja3Spec struct {
+	TLSVersMin          uint16   //最低tls版本,为0时根据扩展自动识别
+	TLSVersMax          uint16   //最高tls版本,为0时根据扩展自动识别
+	CipherSuites        []uint16 //加密套件,按顺序发送
+	Extensions          []uint16 //扩展,按顺序发送
+	Curves              []uint16 //椭圆曲线
+	PointFormats        []uint8  //椭圆曲线点格式
+	SignatureAlgorithms []uint16 //签名算法
+	Alpn                []string //alpn协议,default:h2,http/1.1
+	id                  utls.ClientHelloID
+}
+
+var ja3Ids = map[string]utls.ClientHelloID{
+	"chrome":     utls.HelloChrome_Auto,
+	"chrome58":   utls.HelloChrome_58,
+	"chrome62":   utls.HelloChrome_62,
+	"chrome70":   utls.HelloChrome_70,
+	"chrome72":   utls.HelloChrome_72,
+	"chrome83":   utls.HelloChrome_83,
+	"chrome87":   utls.HelloChrome_87,
+	"chrome96":   utls.HelloChrome_96,
+	"chrome100":  utls.HelloChrome_100,
+	"chrome102":  utls.HelloChrome_102,
+	"chrome106":  utls.HelloChrome_106_Shuffle,
+	"firefox":    utls.HelloFirefox_Auto,
+	"firefox55":  utls.HelloFirefox_55,
+	"firefox56":  utls.HelloFirefox_56,
+	"firefox63":  utls.HelloFirefox_63,
+	"firefox65":  utls.HelloFirefox_65,
+	"firefox99":  utls.HelloFirefox_99,
+	"firefox102": utls.HelloFirefox_102,
+	"firefox105": utls.HelloFirefox_105,
+	"safari":     utls.HelloSafari_Auto,
+	"safari16":   utls.HelloSafari_16_0,
+	"edge":       utls.HelloEdge_Auto,
+	"edge85":     utls.HelloEdge_85,
+	"edge106":    utls.HelloEdge_106,
+	"ios":        utls.HelloIOS_Auto,
+	"ios11":      utls.HelloIOS_11_1,
+	"ios12":      utls.HelloIOS_12_1,
+	"ios13":      utls.HelloIOS_13,
+	"ios14":      utls.HelloIOS_14,
+	"android11":  utls.HelloAndroid_11_OkHttp,
+	"360":        utls.Hello360_Auto,
+	"qq":         utls.HelloQQ_Auto,
+}
+
+// 所有预设指纹的名称
+func Ja3Names() []string {
+	names := make([]string, 0, len(ja3Ids))
+	for name := range ja3Ids {
+		names = append(names, name)
+	}
+	return names
+}
+
+// 根据预设名称生成ja3指纹,例如:chrome,chrome106,firefox,safari,edge,ios14
+func CreateJa3SpecWithName(name string) (Ja3Spec, error) {
+	id, ok := ja3Ids[strings.ToLower(name)]
+	if !ok {
+		return Ja3Spec{}, errors.New("not found ja3 name: " + name)
+	}
+	return CreateJa3SpecWithId(id), nil
+}
+
+// 根据utls 的 ClientHelloID 生成ja3指纹
+func CreateJa3SpecWithId(id utls.ClientHelloID) Ja3Spec {
+	return Ja3Spec{id: id}
+}
+
+// 根据ja3字符串生成指纹,例如:771,4865-4866-4867-49195,0-23-65281-10-11-35-16-5-13-18-51-45-43-27-21,29-23-24,0
+func CreateJa3SpecWithStr(ja3Str string) (Ja3Spec, error) {
+	var spec Ja3Spec
+	tokens := strings.Split(strings.TrimSpace(ja3Str), ",")
+	if len(tokens) != 5 {
+		return spec, errors.New("ja3Str format error")
+	}
+	ver, err := strconv.ParseUint(tokens[0], 10, 16)
+	if err != nil {
+		return spec, errors.New("ja3Str tls version error")
+	}
+	if spec.CipherSuites, err = ja3Uint16s(tokens[1]); err != nil {
+		return spec, errors.New("ja3Str cipherSuites error")
+	}
+	if spec.Extensions, err = ja3Uint16s(tokens[2]); err != nil {
+		return spec, errors.New("ja3Str extensions error")
+	}
+	if spec.Curves, err = ja3Uint16s(tokens[3]); err != nil {
+		return spec, errors.New("ja3Str curves error")
+	}
+	points, err := ja3Uint16s(tokens[4])
+	if err != nil {
+		return spec, errors.New("ja3Str pointFormats error")
+	}
+	for _, point := range points {
+		spec.PointFormats = append(spec.PointFormats, uint8(point))
+	}
+	if len(spec.CipherSuites) == 0 {
+		return spec, errors.New("ja3Str cipherSuites is empty")
+	}
+	if !spec.hasExtension(43) { //没有supported_versions 扩展时,使用ja3 中的版本
+		spec.TLSVersMin = utls.VersionTLS10
+		spec.TLSVersMax = uint16(ver)
+	}
+	return spec, nil
+}
+func ja3Uint16s(val string) ([]uint16, error) {
+	results := []uint16{}
+	if val == "" {
+		return results, nil
+	}
+	for _, item := range strings.Split(val, "-") {
+		num, err := strconv.ParseUint(item, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		if isGrease(uint16(num)) {
+			num = uint64(GreaseValue)
+		}
+		results = append(results, uint16(num))
+	}
+	return results, nil
+}
+func isGrease(val uint16) bool {
+	return val>>8 == val&0xff && val&0xf == 0xa
+}
+
+// 是否设置了指纹
+func (obj Ja3Spec) IsSet() bool {
+	return obj.id.Client != "" || len(obj.CipherSuites) > 0 || len(obj.Extensions) > 0
+}
+
+// 指纹的唯一标识,用来区分连接
+func (obj Ja3Spec) key() string {
+	if !obj.IsSet() {
+		return ""
+	}
+	return fmt.Sprint(obj.id, obj.TLSVersMin, obj.TLSVersMax, obj.CipherSuites, obj.Extensions, obj.Curves, obj.PointFormats, obj.SignatureAlgorithms, obj.Alpn)
+}
+func (obj Ja3Spec) hasExtension(id uint16) bool {
+	for _, extension := range obj.Extensions {
+		if extension == id {
+			return true
+		}
+	}
+	return false
+}
+
+// 生成utls 的ClientHelloSpec,握手时会修改spec 中的扩展,所以每个连接都需要重新生成
+func (obj Ja3Spec) Spec() (utls.ClientHelloSpec, error) {
+	var spec utls.ClientHelloSpec
+	var err error
+	if !obj.IsSet() {
+		spec, err = utls.UTLSIdToSpec(utls.HelloChrome_Auto)
+	} else if obj.id.Client != "" {
+		spec, err = utls.UTLSIdToSpec(obj.id)
+	} else {
+		spec, err = obj.customSpec()
+	}
+	if err != nil {
+		return spec, err
+	}
+	if len(obj.Alpn) > 0 {
+		for _, extension := range spec.Extensions {
+			if alpn, ok := extension.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = append([]string{}, obj.Alpn...)
+			}
+		}
+	}
+	return spec, nil
+}
+
+var defaultSignatureAlgorithms = []utls.SignatureScheme{
+	utls.ECDSAWithP256AndSHA256,
+	utls.PSSWithSHA256,
+	utls.PKCS1WithSHA256,
+	utls.ECDSAWithP384AndSHA384,
+	utls.PSSWithSHA384,
+	utls.PKCS1WithSHA384,
+	utls.PSSWithSHA512,
+	utls.PKCS1WithSHA512,
+}
+
+func (obj Ja3Spec) customSpec() (utls.ClientHelloSpec, error) {
+	spec := utls.ClientHelloSpec{
+		TLSVersMin:         obj.TLSVersMin,
+		TLSVersMax:         obj.TLSVersMax,
+		CipherSuites:       append([]uint16{}, obj.CipherSuites...),
+		CompressionMethods: []uint8{0},
+	}
+	curves := []utls.CurveID{}
+	for _, curve := range obj.Curves {
+		curves = append(curves, utls.CurveID(curve))
+	}
+	if len(curves) == 0 {
+		curves = []utls.CurveID{utls.X25519, utls.CurveP256, utls.CurveP384}
+	}
+	points := append([]uint8{}, obj.PointFormats...)
+	if len(points) == 0 {
+		points = []uint8{0}
+	}
+	signatureAlgorithms := []utls.SignatureScheme{}
+	for _, algorithm := range obj.SignatureAlgorithms {
+		signatureAlgorithms = append(signatureAlgorithms, utls.SignatureScheme(algorithm))
+	}
+	if len(signatureAlgorithms) == 0 {
+		signatureAlgorithms = append(signatureAlgorithms, defaultSignatureAlgorithms...)
+	}
+	alpn := append([]string{}, obj.Alpn...)
+	if len(alpn) == 0 {
+		alpn = []string{"h2", "http/1.1"}
+	}
+	var grease bool
+	for _, cipherSuite := range obj.CipherSuites {
+		if cipherSuite == GreaseValue {
+			grease = true
+		}
+	}
+	var greaseNum int
+	for _, id := range obj.Extensions {
+		if id == GreaseValue {
+			greaseNum++
+			if greaseNum > 2 { //utls 最多支持两个grease 扩展
+				continue
+			}
+			spec.Extensions = append(spec.Extensions, &utls.UtlsGREASEExtension{})
+			continue
+		}
+		extension, err := createExtension(id, obj.TLSVersMax, grease, curves, points, signatureAlgorithms, alpn)
+		if err != nil {
+			return spec, err
+		}
+		if extension != nil {
+			spec.Extensions = append(spec.Extensions, extension)
+		}
+	}
+	return spec, nil
+}
+
+// 根据扩展id 生成扩展,扩展的内容参考chrome
+func createExtension(id uint16, tlsVersMax uint16, grease bool, curves []utls.CurveID, points []uint8, signatureAlgorithms []utls.SignatureScheme, alpn []string) (utls.TLSExtension, error) {
+	switch id {
+	case 0:
+		return &utls.SNIExtension{}, nil
+	case 5:
+		return &utls.StatusRequestExtension{}, nil
+	case 10:
+		return &utls.SupportedCurvesExtension{Curves: append([]utls.CurveID{}, curves...)}, nil
+	case 11:
+		return &utls.SupportedPointsExtension{SupportedPoints: append([]uint8{}, points...)}, nil
+	case 13:
+		return &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: signatureAlgorithms}, nil
+	case 16:
+		return &utls.ALPNExtension{AlpnProtocols: alpn}, nil
+	case 17:
+		return &utls.StatusRequestV2Extension{}, nil
+	case 18:
+		return &utls.SCTExtension{}, nil
+	case 21:
+		return &utls.UtlsPaddingExtension{GetPaddingLen: utls.BoringPaddingStyle}, nil
+	case 23:
+		return &utls.UtlsExtendedMasterSecretExtension{}, nil
+	case 27:
+		return &utls.UtlsCompressCertExtension{Algorithms: []utls.CertCompressionAlgo{utls.CertCompressionBrotli}}, nil
+	case 28:
+		return &utls.FakeRecordSizeLimitExtension{Limit: 0x4001}, nil
+	case 34:
+		return &utls.FakeDelegatedCredentialsExtension{SupportedSignatureAlgorithms: []utls.SignatureScheme{
+			utls.ECDSAWithP256AndSHA256,
+			utls.ECDSAWithP384AndSHA384,
+			utls.ECDSAWithP521AndSHA512,
+			utls.ECDSAWithSHA1,
+		}}, nil
+	case 35:
+		return &utls.SessionTicketExtension{}, nil
+	case 41, 42: //pre_shared_key,early_data 需要会话恢复,utls 不支持,忽略
+		return nil, nil
+	case 43:
+		versions := []uint16{}
+		if grease {
+			versions = append(versions, GreaseValue)
+		}
+		if tlsVersMax == 0 || tlsVersMax >= utls.VersionTLS13 {
+			versions = append(versions, utls.VersionTLS13)
+		}
+		versions = append(versions, utls.VersionTLS12)
+		return &utls.SupportedVersionsExtension{Versions: versions}, nil
+	case 45:
+		return &utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}}, nil
+	case 50:
+		return &utls.SignatureAlgorithmsCertExtension{SupportedSignatureAlgorithms: signatureAlgorithms}, nil
+	case 51:
+		keyShares := []utls.KeyShare{}
+		for _, curve := range curves {
+			if curve == utls.CurveID(GreaseValue) {
+				keyShares = append(keyShares, utls.KeyShare{Group: curve, Data: []byte{0}})
+				continue
+			}
+			switch curve {
+			case utls.X25519, utls.CurveP256, utls.CurveP384, utls.CurveP521:
+				return &utls.KeyShareExtension{KeyShares: append(keyShares, utls.KeyShare{Group: curve})}, nil
+			}
+		}
+		return nil, errors.New("not found key share curve")
+	case 13172:
+		return &utls.NPNExtension{}, nil
+	case 17513:
+		return &utls.ApplicationSettingsExtension{SupportedProtocols: []string{"h2"}}, nil
+	case 30031:
+		return &utls.FakeChannelIDExtension{OldExtensionID: true}, nil
+	case 30032:
+		return &utls.FakeChannelIDExtension{}, nil
+	case 65281:
+		return &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient}, nil
+	default:
+		return &utls.GenericExtension{Id: id}, nil
+	}
+}
+
+// http2 指纹,可以通过 CreateH2SpecWithStr,CreateH2SpecWithName 生成
+type H2Ja3Spec struct {
+	InitialSetting    []http2.Setting     //SETTINGS 帧中的设置,按顺序发送
+	ConnFlow          uint32              //连接建立后发送的WINDOW_UPDATE 增量
+	PseudoHeaderOrder []string            //伪头部顺序,default::method,:authority,:scheme,:path
+	Priority          http2.PriorityParam //HEADERS 帧中的优先级,Weight 为权重-1
+	PriorityFrames    []H2PriorityFrame   //连接建立后发送的PRIORITY 帧
+}
+
+// 连接建立后发送的PRIORITY 帧
+type H2PriorityFrame struct {
+	StreamID uint32
+	Priority http2.PriorityParam
+}
+
+var h2Ja3Specs = map[string]struct {
+	spec     string
+	priority http2.PriorityParam
+}{
+	"chrome":  {"1:65536;2:0;3:1000;4:6291456;6:262144|15663105|0|m,a,s,p", http2.PriorityParam{Weight: 255, Exclusive: true}},
+	"edge":    {"1:65536;2:0;3:1000;4:6291456;6:262144|15663105|0|m,a,s,p", http2.PriorityParam{Weight: 255, Exclusive: true}},
+	"firefox": {"1:65536;4:131072;5:16384|12517377|3:0:0:201,5:0:0:101,7:0:0:1,9:0:7:1,11:0:3:1,13:0:0:241|m,p,a,s", http2.PriorityParam{StreamDep: 13, Weight: 41}},
+	"safari":  {"4:4194304;3:100|10485760|0|m,s,p,a", http2.PriorityParam{Weight: 254}},
+	"ios":     {"4:2097152;3:100|10485760|0|m,s,p,a", http2.PriorityParam{Weight: 254}},
+	"golang":  {"2:0;4:4194304;6:10485760|1073741824|0|a,m,p,s", http2.PriorityParam{}},
+}
+
+// 根据预设名称生成http2指纹,支持:chrome,edge,firefox,safari,ios,golang
+func CreateH2SpecWithName(name string) (H2Ja3Spec, error) {
+	preset, ok := h2Ja3Specs[strings.ToLower(name)]
+	if !ok {
+		return H2Ja3Spec{}, errors.New("not found h2 ja3 name: " + name)
+	}
+	spec, err := CreateH2SpecWithStr(preset.spec)
+	if err != nil {
+		return spec, err
+	}
+	spec.Priority = preset.priority
+	return spec, nil
+}
+
+// 根据akamai 格式的http2指纹生成,例如:1:65536;2:0;3:1000;4:6291456;6:262144|15663105|0|m,a,s,p
+func CreateH2SpecWithStr(h2ja3SpecStr string) (H2Ja3Spec, error) {
+	var spec H2Ja3Spec
+	tokens := strings.Split(strings.TrimSpace(h2ja3SpecStr), "|")
+	if len(tokens) != 4 {
+		return spec, errors.New("h2 ja3 spec format error")
+	}
+	for _, setting := range strings.Split(tokens[0], ";") {
+		if setting == "" {
+			continue
+		}
+		id, val, ok := strings.Cut(setting, ":")
+		if !ok {
+			return spec, errors.New("h2 ja3 settings format error")
+		}
+		settingId, err := strconv.ParseUint(id, 10, 16)
+		if err != nil {
+			return spec, errors.New("h2 ja3 settings id error")
+		}
+		settingVal, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return spec, errors.New("h2 ja3 settings val error")
+		}
+		spec.InitialSetting = append(spec.InitialSetting, http2.Setting{ID: http2.SettingID(settingId), Val: uint32(settingVal)})
+	}
+	connFlow, err := strconv.ParseUint(tokens[1], 10, 32)
+	if err != nil {
+		return spec, errors.New("h2 ja3 window update error")
+	}
+	spec.ConnFlow = uint32(connFlow)
+	if tokens[2] != "0" && tokens[2] != "" {
+		for _, priority := range strings.Split(tokens[2], ",") {
+			vals := strings.Split(priority, ":")
+			if len(vals) != 4 {
+				return spec, errors.New("h2 ja3 priority format error")
+			}
+			nums := make([]uint64, 4)
+			for i, val := range vals {
+				if nums[i], err = strconv.ParseUint(val, 10, 32); err != nil {
+					return spec, errors.New("h2 ja3 priority error")
+				}
+			}
+			if nums[3] < 1 || nums[3] > 256 {
+				return spec, errors.New("h2 ja3 priority weight error")
+			}
+			spec.PriorityFrames = append(spec.PriorityFrames, H2PriorityFrame{
+				StreamID: uint32(nums[0]),
+				Priority: http2.PriorityParam{
+					Exclusive: nums[1] == 1,
+					StreamDep: uint32(nums[2]),
+					Weight:    uint8(nums[3] - 1),
+				},
+			})
+		}
+	}
+	for _, pseudo := range strings.Split(tokens[3], ",") {
+		switch pseudo {
+		case "m":
+			spec.PseudoHeaderOrder = append(spec.PseudoHeaderOrder, ":method")
+		case "a":
+			spec.PseudoHeaderOrder = append(spec.PseudoHeaderOrder, ":authority")
+		case "s":
+			spec.PseudoHeaderOrder = append(spec.PseudoHeaderOrder, ":scheme")
+		case "p":
+			spec.PseudoHeaderOrder = append(spec.PseudoHeaderOrder, ":path")
+		default:
+			return spec, errors.New("h2 ja3 pseudo header order error")
+		}
+	}
+	return spec, nil
+}
+
+// 是否设置了http2指纹
+func (obj H2Ja3Spec) IsSet() bool {
+	return len(obj.InitialSetting) > 0 || obj.ConnFlow > 0 || len(obj.PseudoHeaderOrder) > 0 || len(obj.PriorityFrames) > 0 || !obj.Priority.IsZero()
+}
+func (obj H2Ja3Spec) key() string {
+	if !obj.IsSet() {
+		return ""
+	}
+	return fmt.Sprint(obj.InitialSetting, obj.ConnFlow, obj.PseudoHeaderOrder, obj.Priority, obj.PriorityFrames)
+}
+
+// 与ja3指纹配套的http2指纹
+func (obj Ja3Spec) h2Spec() H2Ja3Spec {
+	var name string
+	switch obj.id.Client {
+	case "Firefox":
+		name = "firefox"
+	case "Safari":
+		name = "safari"
+	case "iOS":
+		name = "ios"
+	case "Golang":
+		name = "golang"
+	default:
+		name = "chrome"
+	}
+	spec, _ := CreateH2SpecWithName(name)
+	return spec
+}
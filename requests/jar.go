@@ -0,0 +1,359 @@
+package requests
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// 可导出所有cookie 的cookie jar
+type CookieJar interface {
+	http.CookieJar
+	AllCookies() []*http.Cookie //所有未过期的cookie,Domain 以"."开头的可用于子域名
+	Clear() error               //清空cookie
+}
+
+// cookie 的持久化存储
+type CookieStore interface {
+	Load() ([]*http.Cookie, error)
+	Save([]*http.Cookie) error
+}
+
+// 默认的cookie jar,设置store 后cookie 变化时在后台保存,Save 或client 关闭时保存剩余的变化
+type Jar struct {
+	lock     sync.Mutex
+	saveLock sync.Mutex //串行写入store
+	jar      *cookiejar.Jar
+	cookies  map[string]*http.Cookie //key:domain;path;name
+	store    CookieStore
+	version  int64 //cookie 变化的版本,lock
+	saved    int64 //已保存的版本,saveLock
+	saving   bool  //是否正在后台保存,lock
+	saveErr  error //最后一次保存的错误,lock
+}
+
+func NewJar(stores ...CookieStore) (*Jar, error) {
+	obj := &Jar{cookies: map[string]*http.Cookie{}}
+	if len(stores) > 0 {
+		obj.store = stores[0]
+	}
+	var err error
+	if obj.jar, err = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List}); err != nil {
+		return nil, err
+	}
+	if obj.store != nil {
+		cookies, err := obj.store.Load()
+		if err != nil {
+			return nil, err
+		}
+		obj.addCookies(cookies)
+	}
+	return obj, nil
+}
+
+// cookie 的默认path
+func cookieDefaultPath(path string) string {
+	if len(path) == 0 || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// 是否已经过期或被删除
+func cookieExpired(cookie *http.Cookie, now time.Time) bool {
+	return cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && !cookie.Expires.After(now))
+}
+func (obj *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	obj.jar.SetCookies(u, cookies)
+	host := u.Hostname()
+	now := time.Now()
+	for _, cookie := range cookies {
+		c := *cookie
+		if c.Domain == "" { //host-only
+			c.Domain = host
+		} else {
+			domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+			if host != domain && !strings.HasSuffix(host, "."+domain) { //不属于该域名的cookie 会被丢弃
+				continue
+			}
+			c.Domain = "." + domain
+		}
+		if c.Path == "" || c.Path[0] != '/' {
+			c.Path = cookieDefaultPath(u.Path)
+		}
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+			c.MaxAge = 0
+		}
+		c.Raw = ""
+		c.Unparsed = nil
+		key := c.Domain + ";" + c.Path + ";" + c.Name
+		if cookieExpired(&c, now) {
+			delete(obj.cookies, key)
+		} else {
+			obj.cookies[key] = &c
+		}
+	}
+	obj.version++
+	if obj.store != nil && !obj.saving { //后台保存,不阻塞请求
+		obj.saving = true
+		go obj.saveLoop()
+	}
+}
+func (obj *Jar) Cookies(u *url.URL) []*http.Cookie {
+	obj.lock.Lock()
+	jar := obj.jar
+	obj.lock.Unlock()
+	return jar.Cookies(u)
+}
+
+// 添加导出的cookie,必须持有lock
+func (obj *Jar) addCookies(cookies []*http.Cookie) {
+	for _, cookie := range cookies {
+		if cookie.Domain == "" {
+			continue
+		}
+		c := *cookie
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		host := strings.TrimPrefix(c.Domain, ".")
+		if !strings.HasPrefix(c.Domain, ".") { //host-only 的cookie 不设置domain
+			c.Domain = ""
+		}
+		obj.jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: c.Path}, []*http.Cookie{&c})
+		c.Domain = cookie.Domain
+		if !cookieExpired(&c, time.Now()) {
+			obj.cookies[c.Domain+";"+c.Path+";"+c.Name] = &c
+		}
+	}
+}
+
+// 保存最新的cookie 到store,不能持有lock,返回保存的版本
+func (obj *Jar) flush() (int64, error) {
+	obj.saveLock.Lock()
+	defer obj.saveLock.Unlock()
+	obj.lock.Lock()
+	version := obj.version
+	if obj.store == nil || version == obj.saved {
+		obj.lock.Unlock()
+		return version, nil
+	}
+	cookies := obj.allCookies()
+	obj.lock.Unlock()
+	err := obj.store.Save(cookies)
+	if err == nil {
+		obj.saved = version
+	}
+	obj.lock.Lock()
+	obj.saveErr = err
+	obj.lock.Unlock()
+	return version, err
+}
+
+// 后台保存,直到没有新的变化或保存失败
+func (obj *Jar) saveLoop() {
+	for {
+		version, err := obj.flush()
+		obj.lock.Lock()
+		if err != nil || version == obj.version {
+			obj.saving = false
+			obj.lock.Unlock()
+			return
+		}
+		obj.lock.Unlock()
+	}
+}
+
+// 最后一次保存到store 的错误
+func (obj *Jar) Err() error {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.saveErr
+}
+func (obj *Jar) allCookies() []*http.Cookie {
+	now := time.Now()
+	cookies := make([]*http.Cookie, 0, len(obj.cookies))
+	for key, cookie := range obj.cookies {
+		if cookieExpired(cookie, now) {
+			delete(obj.cookies, key)
+			continue
+		}
+		c := *cookie
+		cookies = append(cookies, &c)
+	}
+	return cookies
+}
+func (obj *Jar) AllCookies() []*http.Cookie {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.allCookies()
+}
+
+// 导入cookie,Domain 为空的cookie 会被忽略
+func (obj *Jar) AddCookies(cookies ...*http.Cookie) error {
+	obj.lock.Lock()
+	obj.addCookies(cookies)
+	obj.version++
+	obj.lock.Unlock()
+	_, err := obj.flush()
+	return err
+}
+
+// 保存到store,返回保存的错误
+func (obj *Jar) Save() error {
+	_, err := obj.flush()
+	return err
+}
+func (obj *Jar) Clear() error {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return err
+	}
+	obj.lock.Lock()
+	obj.jar = jar
+	obj.cookies = map[string]*http.Cookie{}
+	obj.version++
+	obj.lock.Unlock()
+	_, err = obj.flush()
+	return err
+}
+
+// json 文件存储
+type JsonFileStore struct {
+	path string
+}
+
+func NewJsonFileStore(path string) *JsonFileStore {
+	return &JsonFileStore{path: path}
+}
+func (obj *JsonFileStore) Load() ([]*http.Cookie, error) {
+	con, err := os.ReadFile(obj.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cookies := []*http.Cookie{}
+	if len(con) == 0 {
+		return cookies, nil
+	}
+	return cookies, json.Unmarshal(con, &cookies)
+}
+func (obj *JsonFileStore) Save(cookies []*http.Cookie) error {
+	con, err := json.Marshal(cookies)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(obj.path, con, 0644)
+}
+
+// netscape cookies.txt 文件存储
+type NetscapeFileStore struct {
+	path string
+}
+
+func NewNetscapeFileStore(path string) *NetscapeFileStore {
+	return &NetscapeFileStore{path: path}
+}
+func (obj *NetscapeFileStore) Load() ([]*http.Cookie, error) {
+	file, err := os.Open(obj.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	return ParseNetscapeCookies(file)
+}
+func (obj *NetscapeFileStore) Save(cookies []*http.Cookie) error {
+	return os.WriteFile(obj.path, []byte(FormatNetscapeCookies(cookies)), 0644)
+}
+
+// 解析netscape cookies.txt
+func ParseNetscapeCookies(reader io.Reader) ([]*http.Cookie, error) {
+	cookies := []*http.Cookie{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var httpOnly bool
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return cookies, errors.New("cookies.txt 格式错误:" + line)
+		}
+		cookie := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") && !strings.HasPrefix(cookie.Domain, ".") {
+			cookie.Domain = "." + cookie.Domain
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return cookies, err
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, scanner.Err()
+}
+
+// 生成netscape cookies.txt
+func FormatNetscapeCookies(cookies []*http.Cookie) string {
+	var builder strings.Builder
+	builder.WriteString("# Netscape HTTP Cookie File\n")
+	boolStr := func(val bool) string {
+		if val {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	for _, cookie := range cookies {
+		if cookie.HttpOnly {
+			builder.WriteString("#HttpOnly_")
+		}
+		var expires int64
+		if !cookie.Expires.IsZero() {
+			expires = cookie.Expires.Unix()
+		}
+		builder.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s\n", cookie.Domain, boolStr(strings.HasPrefix(cookie.Domain, ".")), cookie.Path, boolStr(cookie.Secure), expires, cookie.Name, cookie.Value))
+	}
+	return builder.String()
+}
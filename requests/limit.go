@@ -0,0 +1,191 @@
+package requests
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// host 的请求限制,为0 时不限制
+type HostLimit struct {
+	Rate     float64       //每秒请求数
+	Burst    int           //允许突发的请求数,default:1
+	MaxConns int           //最大并发请求数
+	MinDelay time.Duration //每次请求前随机等待的最小时间
+	MaxDelay time.Duration //每次请求前随机等待的最大时间
+}
+
+// host 的请求统计
+type HostStat struct {
+	Requests int64         //请求总数
+	Active   int64         //正在请求的数量
+	Waiting  int64         //等待中的数量
+	WaitTime time.Duration //总等待时间
+}
+
+type hostLimiter struct {
+	lock   sync.Mutex
+	limit  HostLimit
+	tokens float64
+	last   time.Time
+	conns  chan struct{}
+	stat   HostStat
+}
+
+func newHostLimiter(limit HostLimit) *hostLimiter {
+	obj := &hostLimiter{last: time.Now()}
+	obj.setLimit(limit)
+	return obj
+}
+func (obj *hostLimiter) setLimit(limit HostLimit) {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	obj.limit = limit
+	obj.tokens = float64(limit.Burst)
+	if limit.MaxConns > 0 {
+		obj.conns = make(chan struct{}, limit.MaxConns)
+	} else {
+		obj.conns = nil
+	}
+}
+
+// 预留一个令牌,返回需要等待的时间
+func (obj *hostLimiter) reserve() time.Duration {
+	if obj.limit.Rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	obj.tokens += now.Sub(obj.last).Seconds() * obj.limit.Rate
+	if obj.tokens > float64(obj.limit.Burst) {
+		obj.tokens = float64(obj.limit.Burst)
+	}
+	obj.last = now
+	obj.tokens--
+	if obj.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-obj.tokens / obj.limit.Rate * float64(time.Second))
+}
+
+// 等待可以请求,返回请求结束后需要调用的释放方法
+func (obj *hostLimiter) wait(ctx context.Context) (func(), error) {
+	startTime := time.Now()
+	obj.lock.Lock()
+	obj.stat.Waiting++
+	conns := obj.conns
+	limit := obj.limit
+	obj.lock.Unlock()
+	release := func() {
+		obj.lock.Lock()
+		obj.stat.Active--
+		obj.lock.Unlock()
+		if conns != nil {
+			<-conns
+		}
+	}
+	done := func(err error) (func(), error) {
+		obj.lock.Lock()
+		obj.stat.Waiting--
+		obj.stat.WaitTime += time.Since(startTime)
+		if err == nil {
+			obj.stat.Requests++
+			obj.stat.Active++
+		}
+		obj.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return release, nil
+	}
+	if conns != nil {
+		select {
+		case conns <- struct{}{}:
+		case <-ctx.Done():
+			return done(ctx.Err())
+		}
+	}
+	obj.lock.Lock()
+	delay := obj.reserve()
+	obj.lock.Unlock()
+	if limit.MaxDelay > limit.MinDelay {
+		delay += limit.MinDelay + time.Duration(rand.Int63n(int64(limit.MaxDelay-limit.MinDelay)))
+	} else {
+		delay += limit.MinDelay
+	}
+	if err := retrySleep(ctx, delay); err != nil {
+		if conns != nil {
+			<-conns
+		}
+		return done(err)
+	}
+	return done(nil)
+}
+
+type limiter struct {
+	lock         sync.Mutex
+	defaultLimit HostLimit
+	hostLimits   map[string]HostLimit
+	hosts        map[string]*hostLimiter
+}
+
+func newLimiter(session_option ClientOption) *limiter {
+	obj := &limiter{
+		defaultLimit: session_option.HostLimit,
+		hostLimits:   map[string]HostLimit{},
+		hosts:        map[string]*hostLimiter{},
+	}
+	for host, limit := range session_option.HostLimits {
+		obj.hostLimits[host] = limit
+	}
+	return obj
+}
+func (obj *limiter) getHostLimiter(host string) *hostLimiter {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	hostLimit, ok := obj.hosts[host]
+	if !ok {
+		limit, ok := obj.hostLimits[host]
+		if !ok {
+			limit = obj.defaultLimit
+		}
+		hostLimit = newHostLimiter(limit)
+		obj.hosts[host] = hostLimit
+	}
+	return hostLimit
+}
+func (obj *limiter) wait(ctx context.Context, href string) (func(), error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	return obj.getHostLimiter(u.Hostname()).wait(ctx)
+}
+
+// 设置host 的请求限制,立即生效
+func (obj *Client) SetHostLimit(host string, limit HostLimit) {
+	obj.limiter.lock.Lock()
+	obj.limiter.hostLimits[host] = limit
+	hostLimit, ok := obj.limiter.hosts[host]
+	obj.limiter.lock.Unlock()
+	if ok {
+		hostLimit.setLimit(limit)
+	}
+}
+
+// 每个host 的请求统计
+func (obj *Client) HostStats() map[string]HostStat {
+	obj.limiter.lock.Lock()
+	defer obj.limiter.lock.Unlock()
+	stats := make(map[string]HostStat, len(obj.limiter.hosts))
+	for host, hostLimit := range obj.limiter.hosts {
+		hostLimit.lock.Lock()
+		stats[host] = hostLimit.stat
+		hostLimit.lock.Unlock()
+	}
+	return stats
+}
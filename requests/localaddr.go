@@ -0,0 +1,116 @@
+package requests
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// 本地出口ip 池,按目标ip 的版本选择相同版本的出口ip
+//
+// cidr 中的ip 需要已绑定到网卡,ipv6 网段可使用:ip -6 route add local 2001:db8::/64 dev lo
+type LocalAddrOption struct {
+	Addrs []string //出口ip 或cidr,如:192.168.1.2,2001:db8::/64,cidr 时在网段内随机选择ip
+	Mode  string   //选择方式,round:轮询,random:随机,host:同一个host 固定使用同一个ip,default:round
+}
+type LocalAddrPool struct {
+	mode  string
+	nets  [2][]*net.IPNet //0:ipv4,1:ipv6
+	index [2]uint64
+}
+
+func NewLocalAddrPool(option LocalAddrOption) (*LocalAddrPool, error) {
+	obj := &LocalAddrPool{mode: option.Mode}
+	switch obj.mode {
+	case "":
+		obj.mode = "round"
+	case "round", "random", "host":
+	default:
+		return nil, errors.New("LocalAddrOption.Mode 错误:" + option.Mode)
+	}
+	for _, addr := range option.Addrs {
+		var ipNet *net.IPNet
+		if strings.Contains(addr, "/") {
+			_, cidr, err := net.ParseCIDR(addr)
+			if err != nil {
+				return nil, err
+			}
+			ipNet = cidr
+		} else if ip := net.ParseIP(addr); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ipNet = &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+			} else {
+				ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+			}
+		} else {
+			return nil, errors.New("出口ip 错误:" + addr)
+		}
+		family := ipFamily(ipNet.IP)
+		obj.nets[family] = append(obj.nets[family], ipNet)
+	}
+	if len(obj.nets[0]) == 0 && len(obj.nets[1]) == 0 {
+		return nil, errors.New("没有出口ip")
+	}
+	return obj, nil
+}
+
+// 有与ip 版本相同的出口ip
+func (obj *LocalAddrPool) Has(ip net.IP) bool {
+	return len(obj.nets[ipFamily(ip)]) > 0
+}
+
+// 连接host 的ip 时使用的出口ip,没有相同版本的出口ip 时返回nil
+func (obj *LocalAddrPool) Get(host string, ip net.IP) net.IP {
+	family := ipFamily(ip)
+	nets := obj.nets[family]
+	if len(nets) == 0 {
+		return nil
+	}
+	switch obj.mode {
+	case "random":
+		return randIp(nets[rand.Intn(len(nets))], rand.Uint64)
+	case "host":
+		hash := fnv.New64a()
+		hash.Write([]byte(strings.ToLower(host)))
+		sum := hash.Sum64()
+		source := rand.New(rand.NewSource(int64(sum)))
+		return randIp(nets[sum%uint64(len(nets))], source.Uint64)
+	default:
+		index := atomic.AddUint64(&obj.index[family], 1) - 1
+		return randIp(nets[index%uint64(len(nets))], rand.Uint64)
+	}
+}
+func ipFamily(ip net.IP) int {
+	if ipVersion(ip) == 4 {
+		return 0
+	}
+	return 1
+}
+
+// 在网段内随机选择ip,ipv4 跳过网络地址与广播地址
+func randIp(ipNet *net.IPNet, random func() uint64) net.IP {
+	ones, bits := ipNet.Mask.Size()
+	ip := make(net.IP, len(ipNet.IP))
+	for {
+		var val uint64
+		for i := range ip {
+			if i%8 == 0 {
+				val = random()
+			}
+			ip[i] = ipNet.IP[i] | (byte(val>>(8*(i%8))) &^ ipNet.Mask[i])
+		}
+		if bits != 32 || ones >= 31 || (!ip.Equal(ipNet.IP) && !ip.Equal(broadcastIp(ipNet))) {
+			return ip
+		}
+	}
+}
+func broadcastIp(ipNet *net.IPNet) net.IP {
+	ip := make(net.IP, len(ipNet.IP))
+	for i := range ip {
+		ip[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+	return ip
+}
@@ -0,0 +1,154 @@
+package requests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitee.com/baixudong/gospider/blog"
+	"gitee.com/baixudong/gospider/tools"
+)
+
+// 发送一次请求,option 已经初始化,Headers 为http.Header
+type Handler func(ctx context.Context, option *RequestOption) (*Response, error)
+
+// 中间件,可以修改请求参数,不调用next 直接返回,多次调用next 重试,或包装请求
+//
+// 每次尝试都会经过中间件,返回error 时按TryNum,RetryPolicy 重试
+type Middleware func(next Handler) Handler
+
+// 添加client 中间件,按添加顺序执行,在请求中间件之前
+func (obj *Client) Use(middlewares ...Middleware) {
+	obj.middlewareLock.Lock()
+	defer obj.middlewareLock.Unlock()
+	temps := make([]Middleware, 0, len(obj.middlewares)+len(middlewares)) //复制后替换,不影响正在执行的请求
+	temps = append(temps, obj.middlewares...)
+	obj.middlewares = append(temps, middlewares...)
+}
+
+// 经过client 与请求的中间件后发送请求
+func (obj *Client) handle(ctx context.Context, option *RequestOption) (*Response, error) {
+	obj.middlewareLock.Lock()
+	middlewares := obj.middlewares
+	obj.middlewareLock.Unlock()
+	if len(middlewares) == 0 && len(option.Middlewares) == 0 && option.Auth == nil {
+		return obj.tempRequest(ctx, *option)
+	}
+	handler := func(ctx context.Context, option *RequestOption) (*Response, error) {
+		if err := option.optionInit(); err != nil { //中间件可能修改了请求参数
+			return nil, tools.WrapError(errFatal, err)
+		}
+		if option.Body != nil {
+			option.Body.Seek(0, 0)
+		}
+		return obj.tempRequest(ctx, *option)
+	}
+	if option.Auth != nil {
+		handler = authMiddleware(option.Auth)(handler)
+	}
+	for i := len(option.Middlewares) - 1; i >= 0; i-- {
+		handler = option.Middlewares[i](handler)
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler(ctx, option)
+}
+
+// 日志中间件,记录每次请求的method,url,状态码,耗时,错误
+func LogMiddleware(logger *blog.Client) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, option *RequestOption) (*Response, error) {
+			startTime := time.Now()
+			resp, err := next(ctx, option)
+			fields := map[string]any{
+				"method": strings.ToUpper(option.Method),
+				"url":    option.converUrl,
+				"try":    option.CurTryNum,
+				"time":   time.Since(startTime).String(),
+			}
+			if option.Proxy != "" {
+				if proxy, err := url.Parse(option.Proxy); err == nil {
+					proxy.User = nil
+					fields["proxy"] = proxy.String()
+				}
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+				logger.Error("request", fields)
+			} else {
+				fields["status"] = resp.StatusCode()
+				logger.Info("request", fields)
+			}
+			return resp, err
+		}
+	}
+}
+
+// 签名需要的请求信息
+type SignRequest struct {
+	Method  string
+	Url     *url.URL    //包含Params 的完整url
+	Headers http.Header //签名结果直接写入,会随请求发送
+	Body    []byte
+}
+
+// 请求头签名中间件,每次尝试都会重新签名
+func SignMiddleware(sign func(*SignRequest) error) Middleware {
+	return signMiddleware(func(_ context.Context, req *SignRequest) error {
+		return sign(req)
+	})
+}
+func signMiddleware(sign func(context.Context, *SignRequest) error) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, option *RequestOption) (*Response, error) {
+			href, err := url.Parse(option.converUrl)
+			if err != nil {
+				return nil, tools.WrapError(errFatal, err)
+			}
+			headers, ok := option.Headers.(http.Header)
+			if !ok {
+				return nil, tools.WrapError(errFatal, "headers 转换错误")
+			}
+			signReq := &SignRequest{
+				Method:  strings.ToUpper(option.Method),
+				Url:     href,
+				Headers: headers.Clone(), //不修改client 的请求头
+			}
+			if option.Body != nil {
+				option.Body.Seek(0, 0)
+				if signReq.Body, err = io.ReadAll(option.Body); err != nil {
+					return nil, err
+				}
+				option.Body.Seek(0, 0)
+			}
+			if err = sign(ctx, signReq); err != nil {
+				return nil, err
+			}
+			preHeaders := option.Headers
+			option.Headers = signReq.Headers
+			defer func() { option.Headers = preHeaders }() //重试时使用原始请求头重新签名
+			return next(ctx, option)
+		}
+	}
+}
+
+// 响应验证中间件,验证失败时返回validate 的错误并关闭响应
+func ValidateMiddleware(validate func(*Response) error) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, option *RequestOption) (*Response, error) {
+			resp, err := next(ctx, option)
+			if err != nil {
+				return resp, err
+			}
+			if err = validate(resp); err != nil {
+				resp.Close()
+				return nil, err
+			}
+			return resp, nil
+		}
+	}
+}
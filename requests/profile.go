@@ -0,0 +1,313 @@
+package requests
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+)
+
+// 浏览器指纹,User-Agent,请求头,请求头顺序,client hints,ja3 指纹与http2 指纹保持一致,可以通过 GetProfile,RandomProfile 生成
+type Profile struct {
+	Name            string      //名称,如:chrome106,firefox105
+	Browser         string      //浏览器:chrome,edge,firefox,safari
+	UserAgent       string      //User-Agent
+	Accept          string      //Accept
+	AcceptLanguage  string      //Accept-Language
+	AcceptEncoding  string      //Accept-Encoding
+	SecChUa         string      //sec-ch-ua,只有chromium 内核的浏览器发送
+	SecChUaMobile   string      //sec-ch-ua-mobile
+	SecChUaPlatform string      //sec-ch-ua-platform
+	Headers         [][2]string //其它请求头,如:Upgrade-Insecure-Requests,Sec-Fetch-*
+	OrderHeaders    []string    //请求头顺序,http1.1 与http2 都按此顺序发送
+	Ja3Spec         Ja3Spec     //ja3指纹
+	H2Ja3Spec       H2Ja3Spec   //http2指纹
+}
+
+var chromeOrderHeaders = []string{
+	"Host",
+	"Connection",
+	"Content-Length",
+	"Cache-Control",
+	"sec-ch-ua",
+	"sec-ch-ua-mobile",
+	"sec-ch-ua-platform",
+	"Upgrade-Insecure-Requests",
+	"Origin",
+	"Content-Type",
+	"User-Agent",
+	"Accept",
+	"Sec-Fetch-Site",
+	"Sec-Fetch-Mode",
+	"Sec-Fetch-User",
+	"Sec-Fetch-Dest",
+	"Referer",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Cookie",
+}
+var firefoxOrderHeaders = []string{
+	"Host",
+	"User-Agent",
+	"Accept",
+	"Accept-Language",
+	"Accept-Encoding",
+	"Content-Type",
+	"Content-Length",
+	"Origin",
+	"Connection",
+	"Referer",
+	"Cookie",
+	"Upgrade-Insecure-Requests",
+	"Sec-Fetch-Dest",
+	"Sec-Fetch-Mode",
+	"Sec-Fetch-Site",
+	"Sec-Fetch-User",
+}
+var safariOrderHeaders = []string{
+	"Host",
+	"Content-Type",
+	"Origin",
+	"Accept",
+	"Cookie",
+	"User-Agent",
+	"Content-Length",
+	"Accept-Language",
+	"Referer",
+	"Accept-Encoding",
+	"Connection",
+}
+
+// 打开页面时chromium 内核浏览器发送的请求头
+var chromeNavigateHeaders = [][2]string{
+	{"Upgrade-Insecure-Requests", "1"},
+	{"Sec-Fetch-Site", "none"},
+	{"Sec-Fetch-Mode", "navigate"},
+	{"Sec-Fetch-User", "?1"},
+	{"Sec-Fetch-Dest", "document"},
+}
+var firefoxNavigateHeaders = [][2]string{
+	{"Upgrade-Insecure-Requests", "1"},
+	{"Sec-Fetch-Dest", "document"},
+	{"Sec-Fetch-Mode", "navigate"},
+	{"Sec-Fetch-Site", "none"},
+	{"Sec-Fetch-User", "?1"},
+}
+
+const (
+	chromeAccept  = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9"
+	firefoxAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	safariAccept  = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+)
+
+// 预设的浏览器指纹,ja3,h2 为ja3Ids,h2Ja3Specs 中的名称,同一浏览器的最新版本放在第一个
+var profiles = []struct {
+	profile Profile
+	ja3     string
+	h2      string
+}{
+	{Profile{
+		Name:            "chrome106",
+		Browser:         "chrome",
+		UserAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36",
+		Accept:          chromeAccept,
+		AcceptLanguage:  "zh-CN,zh;q=0.9",
+		AcceptEncoding:  "gzip, deflate, br",
+		SecChUa:         `"Chromium";v="106", "Google Chrome";v="106", "Not;A=Brand";v="99"`,
+		SecChUaMobile:   "?0",
+		SecChUaPlatform: `"Windows"`,
+		Headers:         chromeNavigateHeaders,
+		OrderHeaders:    chromeOrderHeaders,
+	}, "chrome106", "chrome"},
+	{Profile{
+		Name:            "chrome106_mac",
+		Browser:         "chrome",
+		UserAgent:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36",
+		Accept:          chromeAccept,
+		AcceptLanguage:  "zh-CN,zh;q=0.9",
+		AcceptEncoding:  "gzip, deflate, br",
+		SecChUa:         `"Chromium";v="106", "Google Chrome";v="106", "Not;A=Brand";v="99"`,
+		SecChUaMobile:   "?0",
+		SecChUaPlatform: `"macOS"`,
+		Headers:         chromeNavigateHeaders,
+		OrderHeaders:    chromeOrderHeaders,
+	}, "chrome106", "chrome"},
+	{Profile{
+		Name:            "chrome102",
+		Browser:         "chrome",
+		UserAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/102.0.0.0 Safari/537.36",
+		Accept:          chromeAccept,
+		AcceptLanguage:  "zh-CN,zh;q=0.9",
+		AcceptEncoding:  "gzip, deflate, br",
+		SecChUa:         `" Not A;Brand";v="99", "Chromium";v="102", "Google Chrome";v="102"`,
+		SecChUaMobile:   "?0",
+		SecChUaPlatform: `"Windows"`,
+		Headers:         chromeNavigateHeaders,
+		OrderHeaders:    chromeOrderHeaders,
+	}, "chrome102", "chrome"},
+	{Profile{
+		Name:            "chrome100",
+		Browser:         "chrome",
+		UserAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/100.0.4896.127 Safari/537.36",
+		Accept:          chromeAccept,
+		AcceptLanguage:  "zh-CN,zh;q=0.9",
+		AcceptEncoding:  "gzip, deflate, br",
+		SecChUa:         `" Not A;Brand";v="99", "Chromium";v="100", "Google Chrome";v="100"`,
+		SecChUaMobile:   "?0",
+		SecChUaPlatform: `"Windows"`,
+		Headers:         chromeNavigateHeaders,
+		OrderHeaders:    chromeOrderHeaders,
+	}, "chrome100", "chrome"},
+	{Profile{
+		Name:            "edge106",
+		Browser:         "edge",
+		UserAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36 Edg/106.0.1370.47",
+		Accept:          chromeAccept,
+		AcceptLanguage:  "zh-CN,zh;q=0.9,en;q=0.8,en-GB;q=0.7,en-US;q=0.6",
+		AcceptEncoding:  "gzip, deflate, br",
+		SecChUa:         `"Chromium";v="106", "Microsoft Edge";v="106", "Not;A=Brand";v="99"`,
+		SecChUaMobile:   "?0",
+		SecChUaPlatform: `"Windows"`,
+		Headers:         chromeNavigateHeaders,
+		OrderHeaders:    chromeOrderHeaders,
+	}, "edge106", "edge"},
+	{Profile{
+		Name:           "firefox105",
+		Browser:        "firefox",
+		UserAgent:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:105.0) Gecko/20100101 Firefox/105.0",
+		Accept:         firefoxAccept,
+		AcceptLanguage: "zh-CN,zh;q=0.8,zh-TW;q=0.7,zh-HK;q=0.5,en-US;q=0.3,en;q=0.2",
+		AcceptEncoding: "gzip, deflate, br",
+		Headers:        firefoxNavigateHeaders,
+		OrderHeaders:   firefoxOrderHeaders,
+	}, "firefox105", "firefox"},
+	{Profile{
+		Name:           "firefox102",
+		Browser:        "firefox",
+		UserAgent:      "Mozilla/5.0 (X11; Linux x86_64; rv:102.0) Gecko/20100101 Firefox/102.0",
+		Accept:         firefoxAccept,
+		AcceptLanguage: "zh-CN,zh;q=0.8,zh-TW;q=0.7,zh-HK;q=0.5,en-US;q=0.3,en;q=0.2",
+		AcceptEncoding: "gzip, deflate, br",
+		Headers:        firefoxNavigateHeaders,
+		OrderHeaders:   firefoxOrderHeaders,
+	}, "firefox102", "firefox"},
+	{Profile{
+		Name:           "safari16",
+		Browser:        "safari",
+		UserAgent:      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.0 Safari/605.1.15",
+		Accept:         safariAccept,
+		AcceptLanguage: "zh-CN,zh-Hans;q=0.9",
+		AcceptEncoding: "gzip, deflate, br",
+		OrderHeaders:   safariOrderHeaders,
+	}, "safari16", "safari"},
+	{Profile{
+		Name:           "ios14",
+		Browser:        "safari",
+		UserAgent:      "Mozilla/5.0 (iPhone; CPU iPhone OS 14_8 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.2 Mobile/15E148 Safari/604.1",
+		Accept:         safariAccept,
+		AcceptLanguage: "zh-CN,zh-Hans;q=0.9",
+		AcceptEncoding: "gzip, deflate, br",
+		OrderHeaders:   safariOrderHeaders,
+	}, "ios14", "ios"},
+}
+
+// 所有预设浏览器指纹的名称
+func ProfileNames() []string {
+	names := make([]string, len(profiles))
+	for i, preset := range profiles {
+		names[i] = preset.profile.Name
+	}
+	return names
+}
+
+// 根据名称生成浏览器指纹,名称为浏览器时返回该浏览器的最新版本,例如:chrome106,firefox105,safari16,chrome,edge,firefox,safari
+func GetProfile(name string) (*Profile, error) {
+	name = strings.ToLower(name)
+	for _, preset := range profiles {
+		if preset.profile.Name == name {
+			return newProfile(preset.profile, preset.ja3, preset.h2)
+		}
+	}
+	for _, preset := range profiles {
+		if preset.profile.Browser == name {
+			return newProfile(preset.profile, preset.ja3, preset.h2)
+		}
+	}
+	return nil, errors.New("not found profile name: " + name)
+}
+
+// 随机生成浏览器指纹,设置browsers 时只在这些浏览器中选择,例如:RandomProfile("chrome","edge")
+func RandomProfile(browsers ...string) *Profile {
+	indexs := []int{}
+	for i, preset := range profiles {
+		if len(browsers) == 0 {
+			indexs = append(indexs, i)
+			continue
+		}
+		for _, browser := range browsers {
+			if strings.EqualFold(preset.profile.Browser, browser) {
+				indexs = append(indexs, i)
+				break
+			}
+		}
+	}
+	if len(indexs) == 0 {
+		return nil
+	}
+	preset := profiles[indexs[rand.Intn(len(indexs))]]
+	profile, _ := newProfile(preset.profile, preset.ja3, preset.h2)
+	return profile
+}
+
+// 复制预设,避免修改预设中的切片
+func newProfile(preset Profile, ja3Name string, h2Name string) (*Profile, error) {
+	profile := preset
+	profile.Headers = append([][2]string{}, preset.Headers...)
+	profile.OrderHeaders = append([]string{}, preset.OrderHeaders...)
+	var err error
+	if profile.Ja3Spec, err = CreateJa3SpecWithName(ja3Name); err != nil {
+		return nil, err
+	}
+	if profile.H2Ja3Spec, err = CreateH2SpecWithName(h2Name); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// 浏览器指纹的默认请求头
+func (obj *Profile) Header() http.Header {
+	head := http.Header{}
+	for _, kv := range [][2]string{
+		{"sec-ch-ua", obj.SecChUa},
+		{"sec-ch-ua-mobile", obj.SecChUaMobile},
+		{"sec-ch-ua-platform", obj.SecChUaPlatform},
+		{"User-Agent", obj.UserAgent},
+		{"Accept", obj.Accept},
+		{"Accept-Encoding", obj.AcceptEncoding},
+		{"Accept-Language", obj.AcceptLanguage},
+	} {
+		if kv[1] != "" {
+			head.Set(kv[0], kv[1])
+		}
+	}
+	for _, kv := range obj.Headers {
+		head.Add(kv[0], kv[1])
+	}
+	return head
+}
+
+// 补全请求中没有设置的请求头,请求头顺序与指纹
+func (obj *Profile) setOption(option *RequestOption) {
+	if option.Headers == nil {
+		option.Headers = obj.Header()
+	}
+	if len(option.OrderHeaders) == 0 {
+		option.OrderHeaders = obj.OrderHeaders
+	}
+	if !option.Ja3Spec.IsSet() {
+		option.Ja3Spec = obj.Ja3Spec
+	}
+	if !option.H2Ja3Spec.IsSet() {
+		option.H2Ja3Spec = obj.H2Ja3Spec
+	}
+}
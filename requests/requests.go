@@ -8,6 +8,7 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptrace"
 	"net/textproto"
 	"net/url"
 	"strings"
@@ -22,12 +23,36 @@ var UserAgent = `Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (K
 
 // 请求操作========================================================================= start
 var defaultHeaders = http.Header{
-	"Accept-encoding": []string{"gzip, deflate, br"},
+	"Accept-Encoding": []string{"gzip, deflate, br"},
 	"Accept":          []string{"text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8"},
 	"Accept-Language": []string{"zh-CN,zh;q=0.9"},
 	"User-Agent":      []string{UserAgent},
 }
 
+// 默认的请求头顺序,与chrome 发送的顺序一致
+var defaultOrderHeaders = []string{
+	"Host",
+	"Connection",
+	"Content-Length",
+	"Cache-Control",
+	"sec-ch-ua",
+	"sec-ch-ua-mobile",
+	"sec-ch-ua-platform",
+	"Upgrade-Insecure-Requests",
+	"Origin",
+	"Content-Type",
+	"User-Agent",
+	"Accept",
+	"Sec-Fetch-Site",
+	"Sec-Fetch-Mode",
+	"Sec-Fetch-User",
+	"Sec-Fetch-Dest",
+	"Referer",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Cookie",
+}
+
 type myInt int
 
 const (
@@ -45,7 +70,13 @@ type reqCtxData struct {
 	redirectNum int
 	disProxy    bool
 	h2          bool
+	h3          bool
 	ja3         bool
+	ja3Spec     Ja3Spec
+	h2Ja3Spec   H2Ja3Spec
+
+	orderHeaders []string
+	caseHeaders  []string //http1.1 保持原始写法的请求头
 }
 type File struct {
 	Key     string //字段的key
@@ -60,7 +91,7 @@ type RequestOption struct {
 	Host               string //host
 	Proxy              string //代理,http,socks5
 	Timeout            int64  //请求超时时间
-	Headers            any    //请求头
+	Headers            any    //请求头,[][2]string 类型的请求头会按顺序发送
 	Cookies            any    // cookies
 	Files              []File //文件
 	Params             any    //url params,url 参数key,val
@@ -72,22 +103,34 @@ type RequestOption struct {
 	TempData           any                                       //临时变量
 	Bytes              []byte                                    //二进制内容
 	DisCookie          bool                                      //关闭cookies管理
+	DisCache           bool                                      //关闭响应缓存
 	DisDecode          bool                                      //关闭自动解码
+	CharsetHook        func(*Response) string                    //修改自动检测的编码,Response.Charset() 为检测结果,返回空时使用检测结果
 	Bar                bool                                      //是否开启bar
 	DisProxy           bool                                      //是否关闭代理
 	Ja3                bool                                      //是否开启ja3
+	Ja3Spec            Ja3Spec                                   //ja3指纹,设置后自动开启ja3
+	H2Ja3Spec          H2Ja3Spec                                 //http2指纹,不设置时使用与ja3指纹配套的http2指纹
+	Profile            *Profile                                  //浏览器指纹,补全没有设置的请求头,请求头顺序,ja3指纹,http2指纹,优先于client 的设置
 	TryNum             int64                                     //重试次数
 	CurTryNum          int64                                     //当前尝试次数
 	BeforCallBack      func(*RequestOption)                      //请求之前回调
 	AfterCallBack      func(*RequestOption, *Response) *Response //请求之后回调
+	Middlewares        []Middleware                              //请求中间件,在client 中间件之后执行
+	Auth               Auth                                      //认证,basic,digest,oauth2,aws sigv4
 	RedirectNum        int                                       //重定向次数
 	DisAlive           bool                                      //关闭长连接
 	DisRead            bool                                      //关闭默认读取请求体
 	DisUnZip           bool                                      //变比自动解压
 	Err                error                                     //请求过程中的error
 	Http2              bool                                      //开启http2 transport
+	Http3              bool                                      //开启http3 transport,只支持https,不支持ja3 指纹,代理只支持socks5
 	WsOption           WsOption                                  //websocket headers
+	RetryPolicy        *RetryPolicy                              //重试策略
+	OrderHeaders       []string                                  //请求头顺序,http1.1 与http2 都按此顺序发送,不区分大小写
+	OriginalHeaderCase bool                                      //http1.1 保持请求头原始的大小写,优先使用OrderHeaders 中的写法
 	compressionOptions *compressionOptions
+	headerNames        []string
 	converUrl          string
 	contentType        string
 }
@@ -157,13 +200,33 @@ func (obj *RequestOption) newHeaders() error {
 	}
 	switch headers := obj.Headers.(type) {
 	case http.Header:
+		if obj.headerNames == nil {
+			obj.headerNames = []string{}
+			for kk := range headers {
+				obj.headerNames = append(obj.headerNames, kk)
+			}
+		}
+		return nil
+	case [][2]string:
+		head := http.Header{}
+		obj.headerNames = []string{}
+		for _, kv := range headers {
+			head.Add(kv[0], kv[1])
+			obj.headerNames = append(obj.headerNames, kv[0])
+		}
+		if len(obj.OrderHeaders) == 0 {
+			obj.OrderHeaders = obj.headerNames
+		}
+		obj.Headers = head
 		return nil
 	case gjson.Result:
 		if !headers.IsObject() {
 			return errors.New("new headers error")
 		}
 		head := http.Header{}
+		obj.headerNames = []string{}
 		for kk, vv := range headers.Map() {
+			obj.headerNames = append(obj.headerNames, kk)
 			if vv.IsArray() {
 				for _, v := range vv.Array() {
 					head.Add(kk, v.String())
@@ -179,6 +242,19 @@ func (obj *RequestOption) newHeaders() error {
 		return obj.newHeaders()
 	}
 }
+
+// 请求头顺序与大小写,http1.1 在写入连接时由orderConn 调整,http2 在编码请求头时调整
+func setOrderHeaders(ctxData *reqCtxData, request_option RequestOption) {
+	ctxData.orderHeaders = request_option.OrderHeaders
+	if len(ctxData.orderHeaders) == 0 {
+		ctxData.orderHeaders = defaultOrderHeaders
+	}
+	if request_option.OriginalHeaderCase { //OrderHeaders 在后,写法优先
+		ctxData.caseHeaders = make([]string, 0, len(request_option.headerNames)+len(ctxData.orderHeaders))
+		ctxData.caseHeaders = append(ctxData.caseHeaders, request_option.headerNames...)
+		ctxData.caseHeaders = append(ctxData.caseHeaders, ctxData.orderHeaders...)
+	}
+}
 func (obj *RequestOption) newCookies() error {
 	if obj.Cookies == nil {
 		return nil
@@ -323,11 +399,11 @@ func (obj *Client) newRequestOption(option *RequestOption) {
 	if option.AfterCallBack == nil {
 		option.AfterCallBack = obj.AfterCallBack
 	}
-	if option.Headers == nil {
-		if obj.Headers == nil {
-			option.Headers = defaultHeaders.Clone()
-		} else {
+	if option.Headers == nil && option.Profile == nil { //请求设置了浏览器指纹时使用指纹的请求头
+		if obj.Headers != nil {
 			option.Headers = obj.Headers
+		} else if obj.profile == nil {
+			option.Headers = defaultHeaders.Clone()
 		}
 	}
 	if !option.Bar {
@@ -348,6 +424,9 @@ func (obj *Client) newRequestOption(option *RequestOption) {
 	if !option.DisDecode {
 		option.DisDecode = obj.DisDecode
 	}
+	if option.CharsetHook == nil {
+		option.CharsetHook = obj.CharsetHook
+	}
 	if !option.DisRead {
 		option.DisRead = obj.DisRead
 	}
@@ -357,9 +436,44 @@ func (obj *Client) newRequestOption(option *RequestOption) {
 	if !option.Http2 {
 		option.Http2 = obj.Http2
 	}
+	if !option.Http3 {
+		option.Http3 = obj.Http3
+	}
 	if !option.Ja3 {
 		option.Ja3 = obj.Ja3
 	}
+	if option.Profile == nil { //请求设置了浏览器指纹时优先使用指纹的设置
+		if !option.Ja3Spec.IsSet() {
+			option.Ja3Spec = obj.ja3Spec
+		}
+		if !option.H2Ja3Spec.IsSet() {
+			option.H2Ja3Spec = obj.h2Ja3Spec
+		}
+		if len(option.OrderHeaders) == 0 {
+			option.OrderHeaders = obj.OrderHeaders
+		}
+	}
+	if option.RetryPolicy == nil {
+		option.RetryPolicy = obj.RetryPolicy
+	}
+	if option.Auth == nil {
+		option.Auth = obj.Auth
+	}
+	if !option.OriginalHeaderCase {
+		option.OriginalHeaderCase = obj.OriginalHeaderCase
+	}
+	if option.Profile == nil {
+		option.Profile = obj.profile
+	}
+	if option.Profile != nil {
+		option.Profile.setOption(option)
+	}
+	if option.Ja3Spec.IsSet() {
+		if !option.Http2 && option.Ja3Spec.key() != obj.ja3Spec.key() { //与client 不同的指纹不能复用连接池中的连接,http2 的连接池会区分指纹
+			option.DisAlive = true
+		}
+		option.Ja3 = true
+	}
 }
 
 func (obj *Client) Request(preCtx context.Context, method string, href string, options ...RequestOption) (*Response, error) {
@@ -391,11 +505,29 @@ func (obj *Client) Request(preCtx context.Context, method string, href string, o
 	}
 	//开始请求
 	var resp *Response
+	var attempts []Attempt
+	var delay time.Duration
+	var authRetry bool
+	var authRetrying bool //认证重试使用与401 相同的代理,不等待
 	for ; option.CurTryNum <= option.TryNum; option.CurTryNum++ {
 		select {
 		case <-preCtx.Done():
 			return nil, preCtx.Err()
 		default:
+			attempt := Attempt{Num: option.CurTryNum}
+			if authRetrying {
+				authRetrying = false
+			} else if option.CurTryNum > 0 && option.RetryPolicy != nil { //按重试策略等待,更换代理
+				if err = retrySleep(preCtx, delay); err != nil {
+					return nil, err
+				}
+				if option.RetryPolicy.ChangeProxy {
+					if err = obj.changeProxy(preCtx, &option); err != nil {
+						return nil, err
+					}
+					attempt.Proxy = option.Proxy
+				}
+			}
 			if option.BeforCallBack != nil {
 				option.BeforCallBack(&option)
 			}
@@ -408,18 +540,61 @@ func (obj *Client) Request(preCtx context.Context, method string, href string, o
 			if option.Body != nil {
 				option.Body.Seek(0, 0)
 			}
-			resp, option.Err = obj.tempRequest(preCtx, option)
+			release, err := obj.limiter.wait(preCtx, option.converUrl) //host 请求限制
+			if err != nil {
+				return nil, err
+			}
+			attempt.Time = time.Now()
+			resp, option.Err = obj.handle(preCtx, &option)
+			if done := resp.bodyDone(); option.Err == nil && done != nil { //流式读取时关闭响应后释放
+				go func() {
+					<-done
+					release()
+				}()
+			} else {
+				release()
+			}
+			attempt.Duration = time.Since(attempt.Time)
+			attempt.Err = option.Err
+			if resp != nil {
+				attempt.StatusCode = resp.StatusCode()
+			}
 			if option.Err != nil && errors.Is(option.Err, errFatal) {
 				return resp, option.Err
 			}
+			if option.Auth != nil && !authRetry && resp != nil && resp.StatusCode() == 401 && option.Auth.Challenge(resp) { //认证重试,不计入重试次数
+				authRetry = true
+				authRetrying = true
+				attempts = append(attempts, attempt)
+				resp.Close()
+				option.CurTryNum--
+				continue
+			}
+			if option.RetryPolicy != nil && option.CurTryNum < option.TryNum && option.RetryPolicy.retry(resp, option.Err) {
+				delay = option.RetryPolicy.delay(option.CurTryNum+1, resp)
+				attempt.Delay = delay
+				attempts = append(attempts, attempt)
+				if resp != nil {
+					resp.Close()
+				}
+				continue
+			}
+			attempts = append(attempts, attempt)
+			if resp != nil {
+				resp.attempts = attempts
+			}
 			if option.AfterCallBack != nil {
 				callBackRespon := option.AfterCallBack(&option, resp)
 				if callBackRespon != nil && option.Err == nil {
+					callBackRespon.attempts = attempts
 					return callBackRespon, option.Err
 				}
 			} else if option.Err == nil {
 				return resp, option.Err
 			}
+			if option.RetryPolicy != nil && option.Err != nil { //重试策略判断不需要重试的错误
+				return resp, option.Err
+			}
 		}
 	}
 	if option.Err != nil {
@@ -449,7 +624,10 @@ func (obj *Client) tempRequest(preCtx context.Context, request_option RequestOpt
 	ctxData := new(reqCtxData)
 	ctxData.disProxy = request_option.DisProxy
 	ctxData.h2 = request_option.Http2
+	ctxData.h3 = request_option.Http3
 	ctxData.ja3 = request_option.Ja3
+	ctxData.ja3Spec = request_option.Ja3Spec
+	ctxData.h2Ja3Spec = request_option.H2Ja3Spec
 	if request_option.Proxy != "" { //代理相关构造
 		tempProxy, err := verifyProxy(request_option.Proxy)
 		if err != nil {
@@ -468,6 +646,13 @@ func (obj *Client) tempRequest(preCtx context.Context, request_option RequestOpt
 	} else {
 		reqCtx, cancel = context.WithCancel(reqCtx)
 	}
+	reqCtx = httptrace.WithClientTrace(reqCtx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { //http1.1 的连接在写入请求前设置请求头顺序
+			if conn, ok := info.Conn.(*orderConn); ok {
+				conn.setOrder(ctxData)
+			}
+		},
+	})
 	defer func() {
 		if err != nil {
 			cancel()
@@ -500,6 +685,10 @@ func (obj *Client) tempRequest(preCtx context.Context, request_option RequestOpt
 	if reqs.Header, headOk = request_option.Headers.(http.Header); !headOk {
 		return response, tools.WrapError(errFatal, "headers 转换错误")
 	}
+	reqs.Header = reqs.Header.Clone()
+	if request_option.Profile != nil && reqs.Header.Get("User-Agent") == "" { //自定义的请求头没有User-Agent 时使用浏览器指纹的
+		reqs.Header.Set("User-Agent", request_option.Profile.UserAgent)
+	}
 
 	if !isWs && reqs.Header.Get("Content-type") == "" && request_option.contentType != "" {
 		reqs.Header.Add("Content-Type", request_option.contentType)
@@ -521,6 +710,9 @@ func (obj *Client) tempRequest(preCtx context.Context, request_option RequestOpt
 			reqs.AddCookie(vv)
 		}
 	}
+	if !request_option.Http2 && !request_option.Http3 && !request_option.DisAlive && obj.proxyChanged(request_option) {
+		request_option.DisAlive = true
+	}
 	if !request_option.Http2 {
 		reqs.Close = request_option.DisAlive
 	}
@@ -532,8 +724,18 @@ func (obj *Client) tempRequest(preCtx context.Context, request_option RequestOpt
 			return response, tools.WrapError(errFatal, err.Error())
 		}
 	}
+	if isWs {
+		request_option.Http3 = false
+	} else if !request_option.Http3 && obj.AutoHttp3 && obj.baseTransport3.hasAltSvc(reqs.URL) { //自动升级http3
+		request_option.Http3 = true
+	}
+	//请求头顺序
+	setOrderHeaders(ctxData, request_option)
 	r, err = obj.getClient(request_option).Do(reqs)
 	if r != nil {
+		if obj.AutoHttp3 {
+			obj.baseTransport3.setAltSvc(r.Request.URL, r.Header.Get("Alt-Svc"))
+		}
 		if isWs {
 			if r.StatusCode == 101 {
 				request_option.DisRead = true
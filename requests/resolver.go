@@ -0,0 +1,217 @@
+package requests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dns 解析参数,Doh,Dot,Server 只使用一个,优先级:Doh>Dot>Server,都不设置时使用系统dns
+type ResolverOption struct {
+	Doh           string              //dns over https 地址,如:https://1.1.1.1/dns-query,域名使用系统dns 解析
+	Dot           string              //dns over tls 地址,如:1.1.1.1:853
+	Server        string              //udp dns 服务器地址,如:8.8.8.8:53
+	Hosts         map[string][]string //固定解析,类似curl --resolve,key:host,value:ip 列表
+	Prefer        int                 //优先使用的ip 版本,4:ipv4,6:ipv6,default:按解析顺序
+	Only          int                 //只使用的ip 版本,4:ipv4,6:ipv6
+	FallbackDelay time.Duration       //Happy Eyeballs 中另一个ip 版本开始连接前的等待时间,default:300ms,负数时关闭
+}
+
+type resolver struct {
+	resolver      *net.Resolver
+	custom        bool //使用自定义dns 服务器
+	hosts         map[string][]net.IP
+	prefer        int
+	only          int
+	fallbackDelay time.Duration
+}
+
+func newResolver(option *ResolverOption) (*resolver, error) {
+	obj := &resolver{
+		resolver:      net.DefaultResolver,
+		fallbackDelay: time.Millisecond * 300,
+	}
+	if option == nil {
+		return obj, nil
+	}
+	obj.prefer = option.Prefer
+	obj.only = option.Only
+	if option.FallbackDelay != 0 {
+		obj.fallbackDelay = option.FallbackDelay
+	}
+	if len(option.Hosts) > 0 {
+		obj.hosts = make(map[string][]net.IP)
+		for host, ipStrs := range option.Hosts {
+			ips := make([]net.IP, len(ipStrs))
+			for i, ipStr := range ipStrs {
+				if ips[i] = net.ParseIP(ipStr); ips[i] == nil {
+					return nil, fmt.Errorf("Hosts 中%s 的ip 错误:%s", host, ipStr)
+				}
+			}
+			obj.hosts[strings.ToLower(host)] = ips
+		}
+	}
+	var dial func(ctx context.Context, network, address string) (net.Conn, error)
+	if option.Doh != "" {
+		href, err := url.Parse(option.Doh)
+		if err != nil {
+			return nil, err
+		}
+		if href.Scheme != "https" {
+			return nil, errors.New("Doh 地址必须是https")
+		}
+		client := &http.Client{Transport: &http.Transport{
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: time.Second * 10,
+			IdleConnTimeout:     time.Second * 90,
+		}}
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, href: option.Doh}, nil
+		}
+	} else if option.Dot != "" {
+		addr := option.Dot
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "853")
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}
+	} else if option.Server != "" {
+		addr := option.Server
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		var dialer net.Dialer
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr) //udp 响应被截断时使用tcp 重新查询
+		}
+	}
+	if dial != nil {
+		obj.custom = true
+		obj.resolver = &net.Resolver{PreferGo: true, Dial: dial}
+	}
+	return obj, nil
+}
+
+// 解析host,返回按Prefer 排序,Only 过滤后的ip
+func (obj *resolver) lookupIp(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ips, ok := obj.hosts[strings.ToLower(host)]
+	if !ok {
+		if obj.custom && !strings.HasSuffix(host, ".") {
+			host += "." //不使用系统的search 域名,避免查询泄露到自定义dns
+		}
+		addrs, err := obj.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		ips = make([]net.IP, len(addrs))
+		for i, addr := range addrs {
+			ips[i] = addr.IP
+		}
+	}
+	return obj.sortIps(ips)
+}
+func (obj *resolver) sortIps(ips []net.IP) ([]net.IP, error) {
+	results := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if obj.only == 0 || ipVersion(ip) == obj.only {
+			results = append(results, ip)
+		}
+	}
+	if len(results) == 0 {
+		if obj.only != 0 {
+			return nil, fmt.Errorf("没有ipv%d 地址", obj.only)
+		}
+		return nil, errors.New("没有解析到ip")
+	}
+	if obj.prefer != 0 {
+		sort.SliceStable(results, func(i, j int) bool {
+			return ipVersion(results[i]) == obj.prefer && ipVersion(results[j]) != obj.prefer
+		})
+	}
+	return results, nil
+}
+func ipVersion(ip net.IP) int {
+	if ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
+
+// 将dns over tcp 的查询转为dns over https(RFC 8484)
+type dohConn struct {
+	ctx    context.Context
+	client *http.Client
+	href   string
+	wbuf   bytes.Buffer
+	rbuf   bytes.Buffer
+}
+
+func (obj *dohConn) Write(b []byte) (int, error) {
+	obj.wbuf.Write(b)
+	con := obj.wbuf.Bytes()
+	if len(con) < 2 || len(con) < 2+int(binary.BigEndian.Uint16(con)) { //等待完整的查询
+		return len(b), nil
+	}
+	msg := con[2 : 2+int(binary.BigEndian.Uint16(con))]
+	req, err := http.NewRequestWithContext(obj.ctx, http.MethodPost, obj.href, bytes.NewReader(msg))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := obj.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("doh 查询失败,状态码:%d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return 0, err
+	}
+	obj.wbuf.Reset()
+	binary.Write(&obj.rbuf, binary.BigEndian, uint16(len(body)))
+	obj.rbuf.Write(body)
+	return len(b), nil
+}
+func (obj *dohConn) Read(b []byte) (int, error) {
+	return obj.rbuf.Read(b)
+}
+func (obj *dohConn) Close() error {
+	return nil
+}
+func (obj *dohConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+func (obj *dohConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// 超时由ctx 控制
+func (obj *dohConn) SetDeadline(t time.Time) error {
+	return nil
+}
+func (obj *dohConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+func (obj *dohConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
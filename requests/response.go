@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strings"
@@ -23,24 +24,34 @@ type Response struct {
 	cnl           context.CancelFunc
 	content       []byte
 	encoding      string
+	charset       tools.CharsetResult
+	charsetHook   func(*Response) string
 	disDecode     bool
 	disUnzip      bool
+	attempts      []Attempt
+	isRead        bool
 }
 
 func (obj *Client) newResponse(r *http.Response, cnl context.CancelFunc, request_option RequestOption) (*Response, error) {
 	response := &Response{response: r, cnl: cnl}
-	if request_option.DisRead { //是否预读
-		return response, nil
-	}
 	if request_option.DisUnZip || r.Uncompressed { //是否解压
 		response.disUnzip = true
 	}
-	response.disDecode = request_option.DisDecode      //是否解码
+	response.disDecode = request_option.DisDecode //是否解码
+	response.charsetHook = request_option.CharsetHook
+	if request_option.DisRead { //是否预读,可以使用Stream 流式读取
+		return response, nil
+	}
 	return response, response.read(request_option.Bar) //读取内容
 }
 func (obj *Response) Response() *http.Response {
 	return obj.response
 }
+
+// 每次请求的记录,包括重试
+func (obj *Response) Attempts() []Attempt {
+	return obj.attempts
+}
 func (obj *Response) WebSocketConn() *websocket.Conn {
 	return obj.webSocketConn
 }
@@ -81,7 +92,13 @@ func (obj *Response) Text(val ...string) string {
 	}
 	return tools.BytesToString(obj.content)
 }
+
+// 使用指定编码解码,encoding 为空时自动检测
 func (obj *Response) Decode(encoding string) {
+	if encoding == "" {
+		obj.charset = tools.DetectCharset(obj.content, obj.ContentType())
+		encoding = obj.charset.Name
+	}
 	if obj.encoding != encoding {
 		obj.encoding = encoding
 		obj.content = tools.Decode(obj.content, encoding)
@@ -117,54 +134,111 @@ func (obj *Response) ContentLength() int64 {
 }
 
 type barBody struct {
-	body *bytes.Buffer
+	body io.Reader
 	bar  *bar.Client
 }
 
-func (obj *barBody) Write(con []byte) (int, error) {
-	l, err := obj.body.Write(con)
-	obj.bar.Print(int64(l))
+func (obj *barBody) Read(con []byte) (int, error) {
+	l, err := obj.body.Read(con)
+	if l > 0 {
+		obj.bar.Print(int64(l))
+	}
 	return l, err
 }
-func (obj *Response) barRead() (*bytes.Buffer, error) {
-	barData := &barBody{
-		bar:  bar.NewClient(obj.response.ContentLength),
-		body: bytes.NewBuffer(nil),
+func (obj *Response) verifyBytes() bool {
+	return strings.Contains(obj.Headers().Get("Accept-Ranges"), "bytes")
+}
+
+// 流式读取body,边读边解压,需要开启DisRead,关闭时会关闭响应
+func (obj *Response) Stream() (io.ReadCloser, error) {
+	if obj.isRead {
+		return io.NopCloser(bytes.NewReader(obj.content)), nil
+	}
+	obj.isRead = true
+	if obj.disUnzip {
+		return &streamBody{body: obj.response.Body, response: obj}, nil
 	}
-	_, err := io.Copy(barData, obj.response.Body)
+	reader, err := tools.ZipDecodeReader(obj.response.Body, obj.ContentEncoding())
 	if err != nil {
-		return nil, err
+		obj.Close()
+		return nil, errors.New("gzip NewReader error: " + err.Error())
 	}
-	return barData.body, nil
+	return &streamBody{body: reader, response: obj}, nil
 }
-func (obj *Response) verifyBytes() bool {
-	return strings.Contains(obj.Headers().Get("Accept-Ranges"), "bytes")
+
+type streamBody struct {
+	body     io.ReadCloser
+	response *Response
 }
 
-func (obj *Response) read(bar bool) error { //读取body,对body 解压，解码操作
-	defer obj.Close()
-	var bBody *bytes.Buffer
-	var err error
-	if bar && obj.ContentLength() > 0 { //是否打印进度条,读取内容
-		bBody, err = obj.barRead()
-	} else {
-		bBody = bytes.NewBuffer(nil)
-		_, err = io.Copy(bBody, obj.response.Body)
+func (obj *streamBody) Read(con []byte) (int, error) {
+	return obj.body.Read(con)
+}
+func (obj *streamBody) Close() error {
+	if obj.body != obj.response.response.Body {
+		obj.body.Close()
 	}
-	if err != nil {
-		return errors.New("io.Copy error: " + err.Error())
+	return obj.response.closeNoRead()
+}
+
+func (obj *Response) read(isBar bool) error { //读取body,对body 解压，解码操作
+	defer obj.Close()
+	obj.isRead = true
+	var body io.Reader = obj.response.Body
+	if isBar && obj.ContentLength() > 0 { //是否打印进度条,按压缩后的长度计算
+		body = &barBody{
+			bar:  bar.NewClient(obj.response.ContentLength),
+			body: body,
+		}
 	}
 	if !obj.disUnzip {
-		if bBody, err = tools.ZipDecode(bBody, obj.ContentEncoding()); err != nil {
+		reader, err := tools.ZipDecodeReader(body, obj.ContentEncoding())
+		if err != nil {
 			return errors.New("gzip NewReader error: " + err.Error())
 		}
+		defer reader.Close()
+		body = reader
+	}
+	bBody := bytes.NewBuffer(nil)
+	if _, err := io.Copy(bBody, body); err != nil {
+		return errors.New("io.Copy error: " + err.Error())
 	}
 	obj.content = bBody.Bytes()
-	if !obj.disDecode && !obj.verifyBytes() {
-		obj.content, obj.encoding = tools.Charset(obj.content, obj.ContentType())
+	if !obj.disDecode && !obj.verifyBytes() && obj.isText() {
+		obj.charset = tools.DetectCharset(obj.content, obj.ContentType())
+		encoding := obj.charset.Name
+		if obj.charsetHook != nil {
+			if hookEncoding := obj.charsetHook(obj); hookEncoding != "" {
+				encoding = hookEncoding
+			}
+		}
+		obj.content = tools.DecodeCharset(obj.content, encoding)
+		obj.encoding = encoding
 	}
 	return nil
 }
+
+// 是否是需要解码的文本,二进制内容不解码
+func (obj *Response) isText() bool {
+	mediaType, _, err := mime.ParseMediaType(obj.ContentType())
+	if err != nil {
+		return true
+	}
+	if strings.HasPrefix(mediaType, "text/") || mediaType == "application/x-www-form-urlencoded" {
+		return true
+	}
+	for _, key := range []string{"json", "xml", "html", "javascript"} {
+		if strings.Contains(mediaType, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// 自动检测的编码,没有解码时为空
+func (obj *Response) Charset() tools.CharsetResult {
+	return obj.charset
+}
 func (obj *Response) Close() error {
 	if obj.cnl != nil {
 		defer obj.cnl()
@@ -178,3 +252,19 @@ func (obj *Response) Close() error {
 	}
 	return nil
 }
+
+// 响应体没有预读时,返回关闭响应或请求结束后关闭的chan,已读取的返回nil
+func (obj *Response) bodyDone() <-chan struct{} {
+	if obj == nil || obj.isRead || obj.response.Request == nil {
+		return nil
+	}
+	return obj.response.Request.Context().Done()
+}
+
+// 不读取剩余的body,直接中断请求后关闭
+func (obj *Response) closeNoRead() error {
+	if obj.cnl != nil {
+		obj.cnl()
+	}
+	return obj.Close()
+}
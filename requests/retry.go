@@ -0,0 +1,157 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var defaultRetryStatusCodes = []int{403, 429, 500, 502, 503, 504}
+var defaultRetryErrors = []error{
+	context.DeadlineExceeded,
+	os.ErrDeadlineExceeded,
+	io.EOF,
+	io.ErrUnexpectedEOF,
+	syscall.ECONNRESET,
+	syscall.ECONNREFUSED,
+	syscall.ECONNABORTED,
+	syscall.EPIPE,
+}
+
+// 重试策略,重试次数使用TryNum
+type RetryPolicy struct {
+	MinDelay      time.Duration               //第一次重试的等待时间,default:500ms
+	MaxDelay      time.Duration               //最大等待时间,Retry-After 也不会超过此时间,default:30s
+	Multiplier    float64                     //指数退避的倍数,default:2
+	Jitter        float64                     //随机抖动的比例,0-1
+	StatusCodes   []int                       //需要重试的状态码,default:403,429,500,502,503,504
+	Errors        []error                     //需要重试的错误,使用errors.Is 判断,default:超时,连接重置,连接拒绝,EOF
+	CheckFunc     func(*Response, error) bool //自定义是否需要重试,设置后忽略StatusCodes 和Errors
+	DisRetryAfter bool                        //不使用响应头中的Retry-After
+	ChangeProxy   bool                        //每次重试都从ClientOption.GetProxy 获取新的代理
+}
+
+// 一次请求的记录
+type Attempt struct {
+	Num        int64         //第几次请求,从0开始
+	StatusCode int           //状态码
+	Err        error         //错误
+	Proxy      string        //重试时更换的代理
+	Time       time.Time     //开始时间
+	Duration   time.Duration //请求耗时
+	Delay      time.Duration //下一次重试前的等待时间
+}
+
+// 是否需要重试
+func (obj *RetryPolicy) retry(resp *Response, err error) bool {
+	if obj.CheckFunc != nil {
+		return obj.CheckFunc(resp, err)
+	}
+	if err != nil {
+		retryErrors := obj.Errors
+		if retryErrors == nil {
+			retryErrors = defaultRetryErrors
+		}
+		for _, retryErr := range retryErrors {
+			if errors.Is(err, retryErr) {
+				return true
+			}
+		}
+		return false
+	}
+	if resp == nil {
+		return false
+	}
+	statusCodes := obj.StatusCodes
+	if statusCodes == nil {
+		statusCodes = defaultRetryStatusCodes
+	}
+	for _, statusCode := range statusCodes {
+		if resp.StatusCode() == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// 第num 次重试前的等待时间,num 从1开始
+func (obj *RetryPolicy) delay(num int64, resp *Response) time.Duration {
+	minDelay := obj.MinDelay
+	if minDelay == 0 {
+		minDelay = time.Millisecond * 500
+	}
+	maxDelay := obj.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = time.Second * 30
+	}
+	multiplier := obj.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	delay := float64(minDelay) * math.Pow(multiplier, float64(num-1))
+	if obj.Jitter > 0 {
+		delay += delay * obj.Jitter * (rand.Float64()*2 - 1)
+	}
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if !obj.DisRetryAfter && resp != nil {
+		if retryAfter := parseRetryAfter(resp.Headers().Get("Retry-After")); float64(retryAfter) > delay {
+			delay = math.Min(float64(retryAfter), float64(maxDelay))
+		}
+	}
+	return time.Duration(delay)
+}
+
+// Retry-After 支持秒数和http 时间
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// 等待重试,ctx 结束时返回错误
+func retrySleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// 重试时从GetProxy 获取新的代理,新代理不能复用连接池中的连接
+func (obj *Client) changeProxy(ctx context.Context, option *RequestOption) error {
+	if obj.getProxy == nil {
+		return nil
+	}
+	u, err := url.Parse(option.Url)
+	if err != nil {
+		return err
+	}
+	if option.Proxy, err = obj.getProxy(ctx, u); err != nil {
+		return err
+	}
+	option.DisAlive = true
+	return nil
+}
@@ -0,0 +1,539 @@
+package requests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// json schema 验证失败的位置与原因
+type SchemaError struct {
+	Path    string //json 路径,如:$.data.items[0].id
+	Keyword string //验证失败的关键字,如:type,required
+	Message string
+}
+
+func (obj SchemaError) Error() string {
+	return obj.Path + ": " + obj.Keyword + ": " + obj.Message
+}
+
+// 所有验证失败的位置
+type SchemaErrors []SchemaError
+
+func (obj SchemaErrors) Error() string {
+	msgs := make([]string, len(obj))
+	for i, err := range obj {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// json schema,支持draft-07 的常用关键字:
+//
+// $ref(本文档内),type,enum,const,properties,patternProperties,additionalProperties,required,minProperties,maxProperties,
+// items,additionalItems,minItems,maxItems,uniqueItems,contains,minimum,maximum,exclusiveMinimum,exclusiveMaximum,multipleOf,
+// minLength,maxLength,pattern,allOf,anyOf,oneOf,not
+type JsonSchema struct {
+	root     any
+	patterns sync.Map
+}
+
+// schema 可以是json 字符串,[]byte 或可以转为json 的值
+func NewJsonSchema(schema any) (*JsonSchema, error) {
+	root, err := jsonValue(schema)
+	if err != nil {
+		return nil, err
+	}
+	switch root.(type) {
+	case map[string]any, bool:
+	default:
+		return nil, errors.New("json schema 必须是object 或bool")
+	}
+	obj := &JsonSchema{root: root}
+	if err = obj.checkRefCycle(); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// 检查只经过$ref,allOf,anyOf,oneOf,not 的循环引用,这些关键字在同一个值上验证,有循环时验证不会结束
+func (obj *JsonSchema) checkRefCycle() error {
+	states := map[string]int{} //1:正在检查,2:没有循环
+	var visit func(schemaAny any) error
+	visit = func(schemaAny any) error {
+		schema, ok := schemaAny.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if ref, ok := schema["$ref"].(string); ok {
+			switch states[ref] {
+			case 1:
+				return errors.New("$ref 循环引用:" + ref)
+			case 0:
+				states[ref] = 1
+				if refSchema, err := obj.resolveRef(ref); err == nil { //找不到的$ref 在验证时报错
+					if err = visit(refSchema); err != nil {
+						return err
+					}
+				}
+				states[ref] = 2
+			}
+		}
+		for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+			if subs, ok := schema[key].([]any); ok {
+				for _, sub := range subs {
+					if err := visit(sub); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if not, ok := schema["not"]; ok {
+			return visit(not)
+		}
+		return nil
+	}
+	var refs []string
+	var walk func(val any)
+	walk = func(val any) {
+		switch data := val.(type) {
+		case map[string]any:
+			if ref, ok := data["$ref"].(string); ok {
+				refs = append(refs, ref)
+			}
+			for _, sub := range data {
+				walk(sub)
+			}
+		case []any:
+			for _, sub := range data {
+				walk(sub)
+			}
+		}
+	}
+	walk(obj.root)
+	for _, ref := range refs {
+		if err := visit(map[string]any{"$ref": ref}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 转为json 解析后的值,数字为json.Number
+func jsonValue(data any) (any, error) {
+	var con []byte
+	switch val := data.(type) {
+	case string:
+		con = []byte(val)
+	case []byte:
+		con = val
+	default:
+		var err error
+		if con, err = json.Marshal(val); err != nil {
+			return nil, err
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(con))
+	decoder.UseNumber()
+	var val any
+	if err := decoder.Decode(&val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// 验证数据,data 可以是json 字符串,[]byte 或可以转为json 的值,失败时返回SchemaErrors
+func (obj *JsonSchema) Validate(data any) error {
+	val, err := jsonValue(data)
+	if err != nil {
+		return SchemaErrors{{Path: "$", Keyword: "json", Message: err.Error()}}
+	}
+	var errs SchemaErrors
+	obj.validate(obj.root, val, "$", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+func (obj *JsonSchema) validate(schemaAny any, val any, path string, errs *SchemaErrors) {
+	addErr := func(keyword string, format string, args ...any) {
+		*errs = append(*errs, SchemaError{Path: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+	schema, ok := schemaAny.(map[string]any)
+	if !ok {
+		if schemaAny == false {
+			addErr("false", "不允许任何值")
+		}
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		refSchema, err := obj.resolveRef(ref)
+		if err != nil {
+			addErr("$ref", "%s", err)
+		} else {
+			obj.validate(refSchema, val, path, errs)
+		}
+	}
+	if types, ok := schema["type"]; ok {
+		var names []string
+		switch typ := types.(type) {
+		case string:
+			names = []string{typ}
+		case []any:
+			for _, name := range typ {
+				if name, ok := name.(string); ok {
+					names = append(names, name)
+				}
+			}
+		}
+		matched := false
+		for _, name := range names {
+			if jsonType(val) == name || (name == "number" && jsonType(val) == "integer") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			addErr("type", "需要%s,实际是%s", strings.Join(names, "|"), jsonType(val))
+			return
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		matched := false
+		for _, item := range enum {
+			if jsonEqual(item, val) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			addErr("enum", "不是允许的值")
+		}
+	}
+	if constVal, ok := schema["const"]; ok && !jsonEqual(constVal, val) {
+		addErr("const", "不等于固定值")
+	}
+	switch data := val.(type) {
+	case map[string]any:
+		obj.validateObject(schema, data, path, errs, addErr)
+	case []any:
+		obj.validateArray(schema, data, path, errs, addErr)
+	case json.Number:
+		obj.validateNumber(schema, data, addErr)
+	case string:
+		obj.validateString(schema, data, addErr)
+	}
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			obj.validate(sub, val, path, errs)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		if obj.matchNum(anyOf, val, path) == 0 {
+			addErr("anyOf", "不匹配任何一个schema")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		if num := obj.matchNum(oneOf, val, path); num != 1 {
+			addErr("oneOf", "需要匹配1 个schema,实际匹配%d 个", num)
+		}
+	}
+	if not, ok := schema["not"]; ok {
+		var subErrs SchemaErrors
+		obj.validate(not, val, path, &subErrs)
+		if len(subErrs) == 0 {
+			addErr("not", "不能匹配schema")
+		}
+	}
+}
+func (obj *JsonSchema) matchNum(schemas []any, val any, path string) int {
+	var num int
+	for _, sub := range schemas {
+		var subErrs SchemaErrors
+		obj.validate(sub, val, path, &subErrs)
+		if len(subErrs) == 0 {
+			num++
+		}
+	}
+	return num
+}
+func (obj *JsonSchema) validateObject(schema map[string]any, data map[string]any, path string, errs *SchemaErrors, addErr func(string, string, ...any)) {
+	if required, ok := schema["required"].([]any); ok {
+		for _, key := range required {
+			if key, ok := key.(string); ok {
+				if _, ok := data[key]; !ok {
+					addErr("required", "缺少字段%s", key)
+				}
+			}
+		}
+	}
+	if num, ok := schemaNumber(schema, "minProperties"); ok && float64(len(data)) < num {
+		addErr("minProperties", "字段数量%d 小于%v", len(data), num)
+	}
+	if num, ok := schemaNumber(schema, "maxProperties"); ok && float64(len(data)) > num {
+		addErr("maxProperties", "字段数量%d 大于%v", len(data), num)
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	patternProperties, _ := schema["patternProperties"].(map[string]any)
+	additional, hasAdditional := schema["additionalProperties"]
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) //错误按字段排序
+	for _, key := range keys {
+		keyPath := jsonPathKey(path, key)
+		matched := false
+		if sub, ok := properties[key]; ok {
+			matched = true
+			obj.validate(sub, data[key], keyPath, errs)
+		}
+		for pattern, sub := range patternProperties {
+			re, err := obj.regexp(pattern)
+			if err != nil {
+				addErr("patternProperties", "%s", err)
+				continue
+			}
+			if re.MatchString(key) {
+				matched = true
+				obj.validate(sub, data[key], keyPath, errs)
+			}
+		}
+		if !matched && hasAdditional {
+			if additional == false {
+				*errs = append(*errs, SchemaError{Path: keyPath, Keyword: "additionalProperties", Message: "不允许的字段"})
+			} else {
+				obj.validate(additional, data[key], keyPath, errs)
+			}
+		}
+	}
+}
+func (obj *JsonSchema) validateArray(schema map[string]any, data []any, path string, errs *SchemaErrors, addErr func(string, string, ...any)) {
+	if num, ok := schemaNumber(schema, "minItems"); ok && float64(len(data)) < num {
+		addErr("minItems", "长度%d 小于%v", len(data), num)
+	}
+	if num, ok := schemaNumber(schema, "maxItems"); ok && float64(len(data)) > num {
+		addErr("maxItems", "长度%d 大于%v", len(data), num)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := 0; i < len(data); i++ {
+			for j := i + 1; j < len(data); j++ {
+				if jsonEqual(data[i], data[j]) {
+					addErr("uniqueItems", "第%d 与第%d 个元素重复", i, j)
+				}
+			}
+		}
+	}
+	switch items := schema["items"].(type) {
+	case []any: //元组
+		for i, val := range data {
+			if i < len(items) {
+				obj.validate(items[i], val, path+"["+strconv.Itoa(i)+"]", errs)
+			} else if additional, ok := schema["additionalItems"]; ok {
+				if additional == false {
+					*errs = append(*errs, SchemaError{Path: path + "[" + strconv.Itoa(i) + "]", Keyword: "additionalItems", Message: "不允许的元素"})
+				} else {
+					obj.validate(additional, val, path+"["+strconv.Itoa(i)+"]", errs)
+				}
+			}
+		}
+	case nil:
+	default:
+		for i, val := range data {
+			obj.validate(items, val, path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	}
+	if contains, ok := schema["contains"]; ok {
+		matched := false
+		for _, val := range data {
+			var subErrs SchemaErrors
+			obj.validate(contains, val, path, &subErrs)
+			if len(subErrs) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			addErr("contains", "没有匹配schema 的元素")
+		}
+	}
+}
+func (obj *JsonSchema) validateNumber(schema map[string]any, data json.Number, addErr func(string, string, ...any)) {
+	val, err := data.Float64()
+	if err != nil {
+		addErr("type", "%s", err)
+		return
+	}
+	if num, ok := schemaNumber(schema, "minimum"); ok {
+		if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive && val <= num { //draft-04
+			addErr("exclusiveMinimum", "%v 需要大于%v", data, num)
+		} else if val < num {
+			addErr("minimum", "%v 小于%v", data, num)
+		}
+	}
+	if num, ok := schemaNumber(schema, "maximum"); ok {
+		if exclusive, _ := schema["exclusiveMaximum"].(bool); exclusive && val >= num {
+			addErr("exclusiveMaximum", "%v 需要小于%v", data, num)
+		} else if val > num {
+			addErr("maximum", "%v 大于%v", data, num)
+		}
+	}
+	if num, ok := schemaNumber(schema, "exclusiveMinimum"); ok && val <= num {
+		addErr("exclusiveMinimum", "%v 需要大于%v", data, num)
+	}
+	if num, ok := schemaNumber(schema, "exclusiveMaximum"); ok && val >= num {
+		addErr("exclusiveMaximum", "%v 需要小于%v", data, num)
+	}
+	if num, ok := schemaNumber(schema, "multipleOf"); ok && num > 0 {
+		if quotient := val / num; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			addErr("multipleOf", "%v 不是%v 的倍数", data, num)
+		}
+	}
+}
+func (obj *JsonSchema) validateString(schema map[string]any, data string, addErr func(string, string, ...any)) {
+	length := utf8.RuneCountInString(data)
+	if num, ok := schemaNumber(schema, "minLength"); ok && float64(length) < num {
+		addErr("minLength", "长度%d 小于%v", length, num)
+	}
+	if num, ok := schemaNumber(schema, "maxLength"); ok && float64(length) > num {
+		addErr("maxLength", "长度%d 大于%v", length, num)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := obj.regexp(pattern)
+		if err != nil {
+			addErr("pattern", "%s", err)
+		} else if !re.MatchString(data) {
+			addErr("pattern", "不匹配%s", pattern)
+		}
+	}
+}
+
+// 解析本文档内的$ref,如:#/definitions/user
+func (obj *JsonSchema) resolveRef(ref string) (any, error) {
+	if ref == "#" {
+		return obj.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, errors.New("只支持本文档内的$ref:" + ref)
+	}
+	node := obj.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token, err := url.PathUnescape(token)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch val := node.(type) {
+		case map[string]any:
+			var ok bool
+			if node, ok = val[token]; !ok {
+				return nil, errors.New("没有找到$ref:" + ref)
+			}
+		case []any:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(val) {
+				return nil, errors.New("没有找到$ref:" + ref)
+			}
+			node = val[index]
+		default:
+			return nil, errors.New("没有找到$ref:" + ref)
+		}
+	}
+	return node, nil
+}
+func (obj *JsonSchema) regexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := obj.patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	obj.patterns.Store(pattern, re)
+	return re, nil
+}
+func schemaNumber(schema map[string]any, key string) (float64, bool) {
+	num, ok := schema[key].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	val, err := num.Float64()
+	return val, err == nil
+}
+func jsonType(val any) string {
+	switch data := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if num, err := data.Float64(); err == nil && num == math.Trunc(num) && !math.IsInf(num, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+func jsonEqual(a, b any) bool {
+	switch aVal := a.(type) {
+	case json.Number:
+		bVal, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		aNum, aErr := aVal.Float64()
+		bNum, bErr := bVal.Float64()
+		return aErr == nil && bErr == nil && aNum == bNum
+	case map[string]any:
+		bVal, ok := b.(map[string]any)
+		if !ok || len(aVal) != len(bVal) {
+			return false
+		}
+		for key, val := range aVal {
+			if bItem, ok := bVal[key]; !ok || !jsonEqual(val, bItem) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		bVal, ok := b.([]any)
+		if !ok || len(aVal) != len(bVal) {
+			return false
+		}
+		for i := range aVal {
+			if !jsonEqual(aVal[i], bVal[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// 字段的json 路径,特殊字符使用["key"]
+func jsonPathKey(path string, key string) string {
+	for _, r := range key {
+		if !(r == '_' || r == '-' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r > 127) {
+			return path + "[" + strconv.Quote(key) + "]"
+		}
+	}
+	if key == "" {
+		return path + `[""]`
+	}
+	return path + "." + key
+}
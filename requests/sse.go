@@ -0,0 +1,239 @@
+package requests
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sse 事件
+type Event struct {
+	Id    string //事件id,没有时为上一个事件的id
+	Event string //事件类型,default:message
+	Data  string //多行data 使用\n 连接
+	Retry int64  //服务器要求的重连间隔,毫秒
+}
+
+// 解析text/event-stream
+type SSE struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	lastId string
+	retry  int64
+}
+
+// 读取sse 事件,需要开启DisRead
+func (obj *Response) SSE() (*SSE, error) {
+	body, err := obj.Stream()
+	if err != nil {
+		return nil, err
+	}
+	return newSSE(body), nil
+}
+func newSSE(body io.ReadCloser) *SSE {
+	return &SSE{body: body, reader: bufio.NewReader(body)}
+}
+
+// 读取下一个事件,结束时返回io.EOF
+func (obj *SSE) Recv() (Event, error) {
+	var data strings.Builder
+	var hasData bool
+	event := Event{}
+	for {
+		line, err := obj.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") { //没有完整的事件时丢弃
+			return event, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" { //空行分发事件
+			if !hasData {
+				event = Event{}
+				continue
+			}
+			event.Id = obj.lastId
+			event.Retry = obj.retry
+			if event.Event == "" {
+				event.Event = "message"
+			}
+			event.Data = strings.TrimSuffix(data.String(), "\n")
+			return event, nil
+		}
+		if strings.HasPrefix(line, ":") { //注释
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			hasData = true
+			data.WriteString(value)
+			data.WriteString("\n")
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				obj.lastId = value
+			}
+		case "retry":
+			if retry, err := strconv.ParseInt(value, 10, 64); err == nil && retry >= 0 {
+				obj.retry = retry
+			}
+		}
+	}
+}
+
+// 最后一个事件的id
+func (obj *SSE) LastId() string {
+	return obj.lastId
+}
+func (obj *SSE) Close() error {
+	return obj.body.Close()
+}
+
+// EventSource 参数
+type EventSourceOption struct {
+	RequestOption
+	Retry       time.Duration //断开后的重连间隔,服务器返回retry 时使用服务器的,default:3s
+	MaxRetry    int           //连续重连失败的最大次数,0 不限制
+	LastEventId string        //第一次连接时的Last-Event-ID
+}
+
+// 自动重连的sse 客户端,与普通请求使用相同的代理,ja3 指纹
+type EventSource struct {
+	client   *Client
+	ctx      context.Context
+	cnl      context.CancelFunc
+	href     string
+	option   EventSourceOption
+	sse      *SSE
+	lastId   string
+	retry    time.Duration
+	retryNum int
+}
+
+func (obj *Client) EventSource(preCtx context.Context, href string, options ...EventSourceOption) (*EventSource, error) {
+	if obj == nil {
+		return nil, errors.New("初始化client失败")
+	}
+	if preCtx == nil {
+		preCtx = obj.ctx
+	}
+	var option EventSourceOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.Retry == 0 {
+		option.Retry = time.Second * 3
+	}
+	if option.Timeout == 0 { //长连接,不使用client 的超时时间
+		option.Timeout = -1
+	}
+	obj.newRequestOption(&option.RequestOption)
+	if err := option.newHeaders(); err != nil {
+		return nil, err
+	}
+	ctx, cnl := context.WithCancel(preCtx)
+	eventSource := &EventSource{
+		client: obj,
+		ctx:    ctx,
+		cnl:    cnl,
+		href:   href,
+		option: option,
+		lastId: option.LastEventId,
+		retry:  option.Retry,
+	}
+	if err := eventSource.connect(); err != nil {
+		cnl()
+		return nil, err
+	}
+	return eventSource, nil
+}
+
+// 建立连接,204 时返回io.EOF 不再重连
+func (obj *EventSource) connect() error {
+	option := obj.option.RequestOption
+	headers := option.Headers.(http.Header).Clone()
+	headers.Set("Accept", "text/event-stream")
+	headers.Set("Cache-Control", "no-cache")
+	if obj.lastId != "" {
+		headers.Set("Last-Event-ID", obj.lastId)
+	}
+	option.Headers = headers
+	option.DisRead = true
+	option.DisCache = true
+	resp, err := obj.client.Request(obj.ctx, "get", obj.href, option)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() == 204 {
+		resp.Close()
+		return io.EOF
+	}
+	if resp.StatusCode() != 200 {
+		resp.closeNoRead()
+		return fmt.Errorf("sse 连接失败,状态码:%d", resp.StatusCode())
+	}
+	if !strings.HasPrefix(resp.ContentType(), "text/event-stream") {
+		resp.closeNoRead()
+		return errors.New("sse 连接失败,Content-Type 错误:" + resp.ContentType())
+	}
+	if obj.sse, err = resp.SSE(); err != nil {
+		return err
+	}
+	obj.sse.lastId = obj.lastId
+	return nil
+}
+
+// 读取下一个事件,断开后使用Last-Event-ID 自动重连,Close 或服务器返回204 后返回io.EOF
+func (obj *EventSource) Recv() (Event, error) {
+	for {
+		if obj.sse != nil {
+			event, err := obj.sse.Recv()
+			if err == nil {
+				obj.retryNum = 0
+				obj.lastId = event.Id
+				if event.Retry > 0 {
+					obj.retry = time.Duration(event.Retry) * time.Millisecond
+				}
+				return event, nil
+			}
+			obj.sse.Close()
+			obj.sse = nil
+		}
+		if obj.ctx.Err() != nil {
+			return Event{}, io.EOF
+		}
+		if obj.option.MaxRetry > 0 && obj.retryNum >= obj.option.MaxRetry {
+			return Event{}, errors.New("sse 超过最大重连次数")
+		}
+		obj.retryNum++
+		if err := retrySleep(obj.ctx, obj.retry); err != nil {
+			return Event{}, io.EOF
+		}
+		if err := obj.connect(); err != nil {
+			if err == io.EOF || obj.ctx.Err() != nil {
+				return Event{}, io.EOF
+			}
+			if errors.Is(err, errFatal) {
+				return Event{}, err
+			}
+		}
+	}
+}
+
+// 最后一个事件的id
+func (obj *EventSource) LastId() string {
+	return obj.lastId
+}
+
+// 关闭连接,可以在其它goroutine 中调用,阻塞中的Recv 会返回io.EOF
+func (obj *EventSource) Close() error {
+	obj.cnl()
+	return nil
+}
@@ -0,0 +1,191 @@
+package requests
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"gitee.com/baixudong/gospider/tools"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// tls 证书参数,crypto/tls 与ja3 的utls 都会使用
+type TlsOption struct {
+	Verify bool                //验证服务器证书,default:不验证
+	RootCa []byte              //pem 格式的根证书,设置后只信任这些根证书,default:系统根证书
+	Pins   map[string][]string //公钥固定,key:host,支持*.example.com,value:sha256/base64 格式的SPKI 指纹,不验证证书时只匹配服务器证书
+	Cert   []byte              //pem 格式的客户端证书
+	Key    []byte              //pem 格式的客户端私钥
+	P12    []byte              //pkcs#12 格式的客户端证书与私钥
+	P12Pwd string              //pkcs#12 的密码
+}
+
+type tlsVerifier struct {
+	verify       bool
+	roots        *x509.CertPool //nil 时使用系统根证书
+	pins         map[string]map[string]struct{}
+	certificates []tls.Certificate
+}
+
+func newTlsVerifier(option *TlsOption) (*tlsVerifier, error) {
+	obj := &tlsVerifier{}
+	if option == nil {
+		return obj, nil
+	}
+	obj.verify = option.Verify
+	if len(option.RootCa) > 0 {
+		obj.roots = x509.NewCertPool()
+		if !obj.roots.AppendCertsFromPEM(option.RootCa) {
+			return nil, errors.New("RootCa 中没有证书")
+		}
+	}
+	if len(option.Pins) > 0 {
+		obj.pins = make(map[string]map[string]struct{})
+		for host, pins := range option.Pins {
+			hostPins := make(map[string]struct{})
+			for _, pin := range pins {
+				pin = strings.TrimPrefix(strings.TrimPrefix(pin, "sha256/"), "/")
+				if hash, err := base64.StdEncoding.DecodeString(pin); err != nil || len(hash) != sha256.Size {
+					return nil, errors.New("Pins 中的指纹错误:" + pin)
+				}
+				hostPins[pin] = struct{}{}
+			}
+			obj.pins[strings.ToLower(host)] = hostPins
+		}
+	}
+	if len(option.P12) > 0 {
+		cert, err := parseP12(option.P12, option.P12Pwd)
+		if err != nil {
+			return nil, err
+		}
+		obj.certificates = append(obj.certificates, cert)
+	} else if len(option.Cert) > 0 {
+		cert, err := tls.X509KeyPair(option.Cert, option.Key)
+		if err != nil {
+			return nil, err
+		}
+		obj.certificates = append(obj.certificates, cert)
+	}
+	return obj, nil
+}
+
+// pkcs#12 转为证书,与私钥的localKeyId 相同的证书放在第一个
+func parseP12(data []byte, password string) (tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var keyPem, leafPem, chainPem []byte
+	var keyId string
+	for _, block := range blocks {
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			keyPem = pem.EncodeToMemory(block)
+			keyId = block.Headers["localKeyId"]
+		}
+	}
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if leafPem == nil && block.Headers["localKeyId"] == keyId {
+			leafPem = pem.EncodeToMemory(block)
+		} else {
+			chainPem = append(chainPem, pem.EncodeToMemory(block)...)
+		}
+	}
+	return tls.X509KeyPair(append(leafPem, chainPem...), keyPem)
+}
+
+// host 的固定公钥,没有时返回nil
+func (obj *tlsVerifier) getPins(host string) map[string]struct{} {
+	host = strings.ToLower(host)
+	if pins, ok := obj.pins[host]; ok {
+		return pins
+	}
+	for {
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return nil
+		}
+		if pins, ok := obj.pins["*."+parent]; ok {
+			return pins
+		}
+		host = parent
+	}
+}
+
+// 验证证书链与固定公钥
+func (obj *tlsVerifier) verifyCerts(serverName string, certs []*x509.Certificate) error {
+	if !obj.verify && obj.pins == nil {
+		return nil
+	}
+	if len(certs) == 0 {
+		return tools.WrapError(errFatal, "服务器没有返回证书")
+	}
+	chains := [][]*x509.Certificate{certs[:1]}
+	if obj.verify {
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		var err error
+		if chains, err = certs[0].Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         obj.roots,
+			Intermediates: intermediates,
+		}); err != nil {
+			return tools.WrapError(errFatal, err)
+		}
+	}
+	pins := obj.getPins(serverName)
+	if pins == nil {
+		return nil
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if _, ok := pins[base64.StdEncoding.EncodeToString(hash[:])]; ok {
+				return nil
+			}
+		}
+	}
+	return tools.WrapError(errFatal, "证书公钥与固定的公钥不匹配:"+serverName)
+}
+
+// serverName 为空时使用连接的ServerName
+func (obj *tlsVerifier) tlsConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true, //由VerifyConnection 验证
+		ServerName:         serverName,
+		Certificates:       obj.certificates,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if serverName == "" {
+				return obj.verifyCerts(state.ServerName, state.PeerCertificates)
+			}
+			return obj.verifyCerts(serverName, state.PeerCertificates)
+		},
+	}
+}
+func (obj *tlsVerifier) utlsConfig(serverName string) *utls.Config {
+	certificates := make([]utls.Certificate, len(obj.certificates))
+	for i, cert := range obj.certificates {
+		certificates[i] = utls.Certificate{
+			Certificate: cert.Certificate,
+			PrivateKey:  cert.PrivateKey,
+			Leaf:        cert.Leaf,
+		}
+	}
+	return &utls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         serverName,
+		Certificates:       certificates,
+		VerifyConnection: func(state utls.ConnectionState) error {
+			return obj.verifyCerts(serverName, state.PeerCertificates)
+		},
+	}
+}
@@ -3,31 +3,23 @@ package requests
 import (
 	"context"
 	"crypto/tls"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
-
-	"golang.org/x/net/http2"
 )
 
-func newHttp2Transport(ctx context.Context, session_option ClientOption, dialCli *dialClient) *http2.Transport {
-	return &http2.Transport{
-		DisableCompression: session_option.DisCompression,
-		TLSClientConfig:    &tls.Config{InsecureSkipVerify: true},
-		DialTLSContext:     dialCli.dialTlsContext2,
-		AllowHTTP:          true,
-		ReadIdleTimeout:    time.Duration(session_option.IdleConnTimeout) * time.Second, //检测连接是否健康的间隔时间
-		PingTimeout:        time.Second * time.Duration(session_option.TLSHandshakeTimeout),
-		WriteByteTimeout:   time.Second * time.Duration(session_option.ResponseHeaderTimeout),
-	}
-}
 func newHttpTransport(ctx context.Context, session_option ClientOption, dialCli *dialClient) http.Transport {
+	userAgent := UserAgent
+	if session_option.Profile != nil {
+		userAgent = session_option.Profile.UserAgent
+	}
 	return http.Transport{
 		MaxIdleConns:        655350,
 		MaxConnsPerHost:     655350,
 		MaxIdleConnsPerHost: 655350,
 		ProxyConnectHeader: http.Header{
-			"User-Agent": []string{UserAgent},
+			"User-Agent": []string{userAgent},
 		},
 		TLSHandshakeTimeout:   time.Second * time.Duration(session_option.TLSHandshakeTimeout),
 		ResponseHeaderTimeout: time.Second * time.Duration(session_option.ResponseHeaderTimeout),
@@ -35,31 +27,31 @@ func newHttpTransport(ctx context.Context, session_option ClientOption, dialCli
 		DisableCompression:    session_option.DisCompression,
 		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
 		IdleConnTimeout:       time.Duration(session_option.IdleConnTimeout) * time.Second, //空闲连接在连接池中的超时时间
-		DialContext:           dialCli.dialContext,
-		DialTLSContext:        dialCli.dialTlsContext,
-		ForceAttemptHTTP2:     true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialCli.dialContext(ctx, network, addr)
+			if err != nil {
+				return conn, err
+			}
+			return &orderConn{Conn: conn}, err
+		},
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialCli.dialTlsContext(ctx, network, addr)
+			if err != nil {
+				return conn, err
+			}
+			return &orderConn{Conn: conn}, err
+		},
+		ForceAttemptHTTP2: true,
 		Proxy: func(r *http.Request) (*url.URL, error) {
 			ctxData := r.Context().Value(keyPrincipalID).(*reqCtxData)
 			ctxData.url = r.URL
-			if ctxData.ja3 {
+			if ctxData.ja3 || r.URL.Scheme == "https" { //https 的代理在dialTlsContext 中处理,保证请求头可以重排
 				return nil, nil
 			}
-			if ctxData.proxy != nil && ctxData.proxy.User != nil {
+			if ctxData.proxy != nil && ctxData.proxy.User != nil && ctxData.proxy.Scheme == "http" { //socks5 的用户名密码在握手中使用,不能移除
 				ctxData.proxyUser, ctxData.proxy.User = ctxData.proxy.User, nil
 			}
 			return ctxData.proxy, nil
 		},
 	}
 }
-
-func cloneTransport(t *http2.Transport) *http2.Transport {
-	return &http2.Transport{
-		DisableCompression: t.DisableCompression,
-		TLSClientConfig:    t.TLSClientConfig,
-		DialTLSContext:     t.DialTLSContext,
-		AllowHTTP:          t.AllowHTTP,
-		ReadIdleTimeout:    t.ReadIdleTimeout, //空闲连接在连接池中的超时时间
-		PingTimeout:        t.PingTimeout,
-		WriteByteTimeout:   t.WriteByteTimeout,
-	}
-}
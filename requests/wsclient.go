@@ -0,0 +1,365 @@
+package requests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// websocket 消息
+type WsMessage struct {
+	Type websocket.MessageType
+	Data []byte
+}
+
+// WsClient 参数,RequestOption.WsOption 控制子协议与permessage-deflate 协商
+type WsClientOption struct {
+	RequestOption
+	PingInterval   time.Duration                                     //ping 间隔,default:30s
+	PingTimeout    time.Duration                                     //等待pong 的超时时间,超时后重连,default:10s
+	DisPing        bool                                              //关闭ping
+	DisReconnect   bool                                              //关闭自动重连
+	ReconnectDelay time.Duration                                     //重连间隔,default:3s
+	MaxReconnect   int                                               //连续重连失败的最大次数,0 不限制
+	StopCodes      []websocket.StatusCode                            //服务器使用这些关闭码关闭时不重连,default:1000
+	ReadLimit      int64                                             //单条消息的最大长度,default:32MB
+	ChanSize       int                                               //消息channel 的缓冲大小,default:100
+	OnConnect      func(ctx context.Context, client *WsClient) error //每次连接成功后调用,用于重新订阅,返回错误时重连
+}
+
+// 自动重连的websocket 客户端,与普通请求使用相同的代理,ja3 指纹
+type WsClient struct {
+	client  *Client
+	ctx     context.Context
+	cnl     context.CancelFunc
+	href    string
+	option  WsClientOption
+	msgs    chan WsMessage
+	lock    sync.Mutex
+	conn    *websocket.Conn
+	resp    *Response
+	ready   chan struct{} //连接成功时关闭
+	err     error
+	status  websocket.StatusCode
+	deflate bool
+	closed  bool
+}
+
+func (obj *Client) WsClient(preCtx context.Context, href string, options ...WsClientOption) (*WsClient, error) {
+	if obj == nil {
+		return nil, errors.New("初始化client失败")
+	}
+	if preCtx == nil {
+		preCtx = obj.ctx
+	}
+	var option WsClientOption
+	if len(options) > 0 {
+		option = options[0]
+	}
+	if option.PingInterval == 0 {
+		option.PingInterval = time.Second * 30
+	}
+	if option.PingTimeout == 0 {
+		option.PingTimeout = time.Second * 10
+	}
+	if option.ReconnectDelay == 0 {
+		option.ReconnectDelay = time.Second * 3
+	}
+	if option.StopCodes == nil {
+		option.StopCodes = []websocket.StatusCode{websocket.StatusNormalClosure}
+	}
+	if option.ReadLimit == 0 {
+		option.ReadLimit = 32 * 1024 * 1024
+	}
+	if option.ChanSize == 0 {
+		option.ChanSize = 100
+	}
+	if option.Timeout == 0 { //长连接,不使用client 的超时时间
+		option.Timeout = -1
+	}
+	obj.newRequestOption(&option.RequestOption)
+	if err := option.newHeaders(); err != nil {
+		return nil, err
+	}
+	option.Http2 = false
+	option.Http3 = false
+	if strings.HasPrefix(href, "http") {
+		href = "ws" + strings.TrimPrefix(href, "http")
+	}
+	ctx, cnl := context.WithCancel(preCtx)
+	wsClient := &WsClient{
+		client: obj,
+		ctx:    ctx,
+		cnl:    cnl,
+		href:   href,
+		option: option,
+		msgs:   make(chan WsMessage, option.ChanSize),
+		ready:  make(chan struct{}),
+		status: -1,
+	}
+	if err := wsClient.connect(); err != nil {
+		cnl()
+		return nil, err
+	}
+	go wsClient.run()
+	return wsClient, nil
+}
+
+// 建立连接
+func (obj *WsClient) connect() error {
+	option := obj.option.RequestOption
+	option.Headers = option.Headers.(http.Header).Clone()
+	option.DisCache = true
+	resp, err := obj.client.Request(obj.ctx, "get", obj.href, option)
+	if err != nil {
+		return err
+	}
+	conn := resp.WebSocketConn()
+	if conn == nil {
+		resp.closeNoRead()
+		return errors.New("websocket 连接失败")
+	}
+	conn.SetReadLimit(obj.option.ReadLimit)
+	obj.lock.Lock()
+	obj.conn = conn
+	obj.resp = resp
+	obj.deflate = strings.Contains(resp.Headers().Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	close(obj.ready)
+	obj.lock.Unlock()
+	return nil
+}
+
+// 断开连接,等待重连
+func (obj *WsClient) disconnect(code websocket.StatusCode, reason string) {
+	obj.lock.Lock()
+	conn, resp := obj.conn, obj.resp
+	if conn != nil {
+		obj.conn = nil
+		obj.resp = nil
+		obj.ready = make(chan struct{})
+	}
+	obj.lock.Unlock()
+	if conn != nil {
+		conn.Close(code, reason)
+		resp.closeNoRead()
+	}
+}
+
+// 读取消息,ping,断开后重连
+func (obj *WsClient) run() {
+	defer close(obj.msgs)
+	defer obj.cnl()
+	var failNum int
+	for {
+		obj.lock.Lock()
+		conn := obj.conn
+		obj.lock.Unlock()
+		connCtx, connCnl := context.WithCancel(obj.ctx)
+		readErr := make(chan error, 1)
+		go func() {
+			readErr <- obj.read(connCtx, conn)
+		}()
+		if !obj.option.DisPing {
+			go obj.ping(connCtx, conn)
+		}
+		if obj.option.OnConnect != nil {
+			if err := obj.option.OnConnect(connCtx, obj); err != nil {
+				conn.Close(websocket.StatusGoingAway, "reconnect")
+			}
+		}
+		err := <-readErr
+		connCnl()
+		status := websocket.CloseStatus(err)
+		obj.lock.Lock()
+		obj.err = err
+		obj.status = status
+		obj.lock.Unlock()
+		obj.disconnect(websocket.StatusGoingAway, "reconnect")
+		obj.lock.Lock()
+		closed := obj.closed
+		obj.lock.Unlock()
+		if closed || obj.ctx.Err() != nil || obj.option.DisReconnect {
+			return
+		}
+		for _, code := range obj.option.StopCodes {
+			if code == status {
+				return
+			}
+		}
+		for {
+			if obj.option.MaxReconnect > 0 && failNum >= obj.option.MaxReconnect {
+				obj.lock.Lock()
+				obj.err = errors.New("websocket 超过最大重连次数")
+				obj.lock.Unlock()
+				return
+			}
+			if retrySleep(obj.ctx, obj.option.ReconnectDelay) != nil {
+				return
+			}
+			if err = obj.connect(); err == nil {
+				failNum = 0
+				break
+			}
+			failNum++
+			obj.lock.Lock()
+			obj.err = err
+			obj.lock.Unlock()
+			if errors.Is(err, errFatal) {
+				return
+			}
+		}
+	}
+}
+func (obj *WsClient) read(ctx context.Context, conn *websocket.Conn) error {
+	for {
+		msgType, data, err := conn.Read(ctx)
+		if err != nil {
+			return err
+		}
+		select {
+		case obj.msgs <- WsMessage{Type: msgType, Data: data}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 定时ping,超时后关闭连接
+func (obj *WsClient) ping(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(obj.option.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, pingCnl := context.WithTimeout(ctx, obj.option.PingTimeout)
+			err := conn.Ping(pingCtx)
+			pingCnl()
+			if err != nil {
+				if ctx.Err() == nil {
+					conn.Close(websocket.StatusGoingAway, "ping timeout")
+				}
+				return
+			}
+		}
+	}
+}
+
+// 等待连接成功
+func (obj *WsClient) getConn(ctx context.Context) (*websocket.Conn, error) {
+	if ctx == nil {
+		ctx = obj.ctx
+	}
+	for {
+		obj.lock.Lock()
+		conn, ready := obj.conn, obj.ready
+		obj.lock.Unlock()
+		if conn != nil {
+			return conn, nil
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-obj.ctx.Done():
+			return nil, errors.New("websocket 已关闭")
+		}
+	}
+}
+
+// 接收消息的channel,WsClient 关闭后channel 关闭
+func (obj *WsClient) Messages() <-chan WsMessage {
+	return obj.msgs
+}
+
+// 读取一条消息,WsClient 关闭后返回Err
+func (obj *WsClient) Read(ctx context.Context) (WsMessage, error) {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	select {
+	case msg, ok := <-obj.msgs:
+		if !ok {
+			if err := obj.Err(); err != nil {
+				return msg, err
+			}
+			return msg, errors.New("websocket 已关闭")
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return WsMessage{}, ctx.Err()
+	}
+}
+
+// 读取一条消息并解析json
+func (obj *WsClient) ReadJson(ctx context.Context, val any) error {
+	msg, err := obj.Read(ctx)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(msg.Data, val)
+}
+
+// 发送消息,重连中会等待连接成功
+func (obj *WsClient) Write(ctx context.Context, msgType websocket.MessageType, data []byte) error {
+	conn, err := obj.getConn(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx == nil {
+		ctx = obj.ctx
+	}
+	return conn.Write(ctx, msgType, data)
+}
+
+// 发送文本消息
+func (obj *WsClient) WriteText(ctx context.Context, text string) error {
+	return obj.Write(ctx, websocket.MessageText, []byte(text))
+}
+
+// 发送json 消息
+func (obj *WsClient) WriteJson(ctx context.Context, val any) error {
+	con, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return obj.Write(ctx, websocket.MessageText, con)
+}
+
+// 最后一次断开的错误
+func (obj *WsClient) Err() error {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.err
+}
+
+// 最后一次断开的关闭码,不是正常关闭时为-1
+func (obj *WsClient) CloseStatus() websocket.StatusCode {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.status
+}
+
+// 当前连接是否协商了permessage-deflate 压缩
+func (obj *WsClient) Compression() bool {
+	obj.lock.Lock()
+	defer obj.lock.Unlock()
+	return obj.deflate
+}
+
+// 使用关闭码1000 关闭连接,不再重连
+func (obj *WsClient) Close() error {
+	obj.lock.Lock()
+	obj.closed = true
+	obj.lock.Unlock()
+	obj.disconnect(websocket.StatusNormalClosure, "")
+	obj.cnl()
+	return nil
+}
@@ -0,0 +1,223 @@
+package tools
+
+import (
+	"bytes"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// 编码检测结果
+type CharsetResult struct {
+	Name       string  //编码名称,如:utf-8,gbk,big5
+	Confidence float64 //可信度,0-1
+	Source     string  //来源:bom,header,meta,xml,detect,default
+}
+
+var (
+	metaCharsetRe = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.\-]+)`)
+	xmlCharsetRe  = regexp.MustCompile(`(?i)^\s*<\?xml[^>]+encoding\s*=\s*["']([a-z0-9_:.\-]+)["']`)
+)
+
+// 统计检测的编码,name 为检测结果的名称,common 判断字符是否是该语言的常用字
+var charsetDetectors = []struct {
+	name   string
+	common func(r rune) bool
+}{
+	{"gb18030", func(r rune) bool { return commonHansSet[r] }},
+	{"big5", func(r rune) bool { return commonHantSet[r] }},
+	{"shift_jis", commonJapanese},
+	{"euc-jp", commonJapanese},
+	{"euc-kr", func(r rune) bool { return commonHangulSet[r] }},
+}
+
+// 平假名,全角片假名,常用汉字
+func commonJapanese(r rune) bool {
+	return (r >= 0x3041 && r <= 0x30FA) || commonHansSet[r] || commonHantSet[r]
+}
+
+var (
+	commonHansSet   = runeSet(commonHans)
+	commonHantSet   = runeSet(commonHant)
+	commonHangulSet = runeSet(commonHangul)
+)
+
+func runeSet(str string) map[rune]bool {
+	set := make(map[rune]bool)
+	for _, r := range str {
+		set[r] = true
+	}
+	return set
+}
+
+// 检测编码,依次参考BOM,Content-Type,<meta>,xml 声明与统计结果,声明的编码与内容不符时使用统计结果
+func DetectCharset(content []byte, contentType string) CharsetResult {
+	if len(content) > 64*1024 { //只检测前64K
+		content = content[:64*1024]
+	}
+	switch {
+	case bytes.HasPrefix(content, []byte{0xEF, 0xBB, 0xBF}):
+		return CharsetResult{Name: "utf-8", Confidence: 1, Source: "bom"}
+	case bytes.HasPrefix(content, []byte{0xFF, 0xFE}):
+		return CharsetResult{Name: "utf-16le", Confidence: 1, Source: "bom"}
+	case bytes.HasPrefix(content, []byte{0xFE, 0xFF}):
+		return CharsetResult{Name: "utf-16be", Confidence: 1, Source: "bom"}
+	}
+	declared := declaredCharset(content, contentType)
+	if isAscii(content) {
+		if declared.Name != "" {
+			declared.Confidence = 1
+			return declared
+		}
+		return CharsetResult{Name: "utf-8", Confidence: 1, Source: "default"}
+	}
+	if validUtf8(content) { //其它编码的多字节内容几乎不可能是合法的utf-8
+		if declared.Name == "utf-8" {
+			declared.Confidence = 1
+			return declared
+		}
+		return CharsetResult{Name: "utf-8", Confidence: 0.99, Source: "detect"}
+	}
+	best := CharsetResult{Source: "detect"}
+	for _, detector := range charsetDetectors {
+		score := charsetScore(content, detector.name, detector.common)
+		if declared.Name != "" && sameCharset(declared.Name, detector.name) {
+			if score > 0.2 { //声明的编码可以正常解码,优先使用
+				declared.Confidence = 0.6 + score*0.4
+				return declared
+			}
+		}
+		if score > best.Confidence {
+			best.Name = detector.name
+			best.Confidence = score
+		}
+	}
+	if best.Confidence >= 0.3 {
+		return best
+	}
+	if declared.Name != "" && declared.Name != "utf-8" { //单字节编码无法统计,相信声明
+		declared.Confidence = 0.5
+		return declared
+	}
+	if best.Confidence > 0 {
+		return best
+	}
+	return CharsetResult{Name: "windows-1252", Confidence: 0.1, Source: "default"}
+}
+
+// 按header,meta,xml 的顺序查找声明的编码,utf-16 声明按utf-8 处理
+func declaredCharset(content []byte, contentType string) CharsetResult {
+	var result CharsetResult
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		result = CharsetResult{Name: params["charset"], Source: "header"}
+	} else {
+		head := content
+		if len(head) > 4096 {
+			head = head[:4096]
+		}
+		if match := metaCharsetRe.FindSubmatch(head); match != nil {
+			result = CharsetResult{Name: string(match[1]), Source: "meta"}
+		} else if match := xmlCharsetRe.FindSubmatch(head); match != nil {
+			result = CharsetResult{Name: string(match[1]), Source: "xml"}
+		}
+	}
+	if result.Name == "" {
+		return result
+	}
+	if _, name := charset.Lookup(result.Name); name != "" {
+		result.Name = name
+	} else {
+		return CharsetResult{}
+	}
+	if strings.HasPrefix(result.Name, "utf-16") {
+		result.Name = "utf-8"
+	}
+	return result
+}
+
+// gbk,gb18030 视为同一种编码
+func sameCharset(a, b string) bool {
+	if a == b {
+		return true
+	}
+	isGb := func(name string) bool { return name == "gbk" || name == "gb18030" }
+	return isGb(a) && isGb(b)
+}
+func isAscii(content []byte) bool {
+	for _, b := range content {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// 截断的内容末尾可能是不完整的字符
+func validUtf8(content []byte) bool {
+	if utf8.Valid(content) {
+		return true
+	}
+	for i := 1; i <= 3 && i <= len(content); i++ {
+		if utf8.RuneStart(content[len(content)-i]) {
+			return !utf8.FullRune(content[len(content)-i:]) && utf8.Valid(content[:len(content)-i])
+		}
+	}
+	return false
+}
+
+// 按编码解码后,常用字的比例减去错误字符的比例
+func charsetScore(content []byte, name string, common func(r rune) bool) float64 {
+	enc, _ := charset.Lookup(name)
+	if enc == nil {
+		return 0
+	}
+	decoded, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return 0
+	}
+	var total, commonNum, errNum int
+	for _, r := range string(decoded) {
+		if r < 0x80 {
+			continue
+		}
+		total++
+		if r == utf8.RuneError {
+			errNum++
+		} else if common(r) {
+			commonNum++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	score := float64(commonNum-errNum*2) / float64(total)
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+// 使用编码名称解码为utf-8,不支持的编码返回原内容
+func DecodeCharset(content []byte, name string) []byte {
+	enc, name := charset.Lookup(name)
+	if enc == nil || name == "utf-8" {
+		return content
+	}
+	decoded, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return content
+	}
+	return decoded
+}
+
+// 简体中文常用字
+const commonHans = "的一是在不了有和人这中大为上个国我以要他时来用们生到作地于出就分对成会可主发年动同工也能下过子说产种面而方后多定行学法所民得经十三之进着等部度家电力里如水化高自二理起小物现实加量都两体制机当使点从业本去把性好应开它合还因由其些然前外天政四日那社义事平形相全表间样与关各重新线内数正心反你明看原又么利比或但质气第向道命此变条只没结解问意建月公无系军很情者最立代想已通并提直题党程展五果料象员革位入常文总次品式活设及管特件长求老头基资边流路级少图山统接知较将组见计别她手角期根论运农指几九区强放决西被干做必战先回则任取据处队南给色光门即保治北造百规热领七海口东导器压志世金增争济阶油思术极交受联什认六共权收证改清己美再采转更单风切打白教速花带安场身车例真务具万每目至达走积示议声报斗完类八离华名确才科张信马节话米整空元况今集温传土许步群广石记需段研界拉林律叫且究观越织装影算低持音众书布复容儿须际商非验连断深难近矿千周委素技备半办青省列习响约支般史感劳便团往酸历市克何除消构府称太准精值号率族维划选标写存候毛亲快效斯院查江型眼王按格养易置派层片始却专状育厂京识适属圆包火住调满县局照参红细引听该铁价严龙飞网络新闻首页登录注册服务信息公司产品中心联系我们关于版权所有简介搜索客户市场管理系统平台查询更多发布时间来源政府公开通知公告办事指南政策文件工作动态。，、：；？！“”（）《》【】"
+
+// 繁体中文常用字
+const commonHant = "的一是在不了有和人這中大為上個國我以要他時來用們生到作地於出就分對成會可主發年動同工也能下過子說產種面而方後多定行學法所民得經十三之進著等部度家電力裡如水化高自二理起小物現實加量都兩體制機當使點從業本去把性好應開它合還因由其些然前外天政四日那社義事平形相全表間樣與關各重新線內數正心反你明看原又麼利比或但質氣第向道命此變條只沒結解問意建月公無系軍很情者最立代想已通並提直題黨程展五果料象員革位入常文總次品式活設及管特件長求老頭基資邊流路級少圖山統接知較將組見計別她手角期根論運農指幾九區強放決西被幹做必戰先回則任取據處隊南給色光門即保治北造百規熱領七海口東導器壓志世金增爭濟階油思術極交受聯什認六共權收證改清己美再採轉更單風切打白教速花帶安場身車例真務具萬每目至達走積示議聲報鬥完類八離華名確才科張信馬節話米整空元況今集溫傳土許步群廣石記需段研界拉林律叫且究觀越織裝影算低持音眾書布復容兒須際商非驗連斷深難近礦千周委素技備半辦青省列習響約支般史感勞便團往酸歷市克何除消構府稱太準精值號率族維劃選標寫存候毛親快效斯院查江型眼王按格養易置派層片始卻專狀育廠京識適屬圓包火住調滿縣局照參紅細引聽該鐵價嚴龍飛網絡新聞首頁登錄註冊服務資訊公司產品中心聯絡我們關於版權所有簡介搜尋客戶市場管理系統平台查詢更多發佈時間來源政府公開通知公告。，、：；？！「」『』（）《》【】"
+
+// 韩文常用字
+const commonHangul = "이다는의에하고을가지로한서기사대어리자도들일있으니를시수과해정인아적전나주스요게제만상부보국없문라구우장성것경내원면동개위조생소비무데여세화연관식거마신중했야러말학오습께입까때받였된그것저우리년월까지또한및등을를에서으로부터에게처럼보다하여하는하고있는없는되는위한대한통해관련경우현재정부기업사회서울한국뉴스기자사진홈로그인회원가입검색"
@@ -37,7 +37,6 @@ import (
 	"gitee.com/baixudong/gospider/kinds"
 	"gitee.com/baixudong/gospider/re"
 
-	"golang.org/x/net/html/charset"
 	"golang.org/x/text/encoding/simplifiedchinese"
 )
 
@@ -83,12 +82,8 @@ func UrlJoin(base, href string) (string, error) {
 
 // 网页解码，并返回 编码
 func Charset(content []byte, content_type string) ([]byte, string) {
-	chset, chset_name, _ := charset.DetermineEncoding(content, content_type)
-	chset_content, err := chset.NewDecoder().Bytes(content)
-	if err != nil {
-		return content, "utf-8"
-	}
-	return chset_content, chset_name
+	chset := DetectCharset(content, content_type)
+	return DecodeCharset(content, chset.Name), chset.Name
 }
 
 // 转码
@@ -102,7 +97,7 @@ func Decode[T string | []byte](txt T, code string) T {
 		case "gbk":
 			result, _ = simplifiedchinese.GBK.NewDecoder().String(val)
 		default:
-			result = val
+			result = string(DecodeCharset(StringToBytes(val), code))
 		}
 	case []byte:
 		switch code {
@@ -111,7 +106,7 @@ func Decode[T string | []byte](txt T, code string) T {
 		case "gbk":
 			result, _ = simplifiedchinese.GBK.NewDecoder().Bytes(val)
 		default:
-			result = val
+			result = DecodeCharset(val, code)
 		}
 	}
 	return result.(T)
@@ -461,6 +456,20 @@ func ZipDecode(r *bytes.Buffer, encoding string) (*bytes.Buffer, error) {
 	return rs, err
 }
 
+// 流式压缩解码,边读边解压
+func ZipDecodeReader(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "deflate":
+		return flate.NewReader(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
 // 字符串转字节串
 func StringToBytes(s string) []byte {
 	return *(*[]byte)(unsafe.Pointer(